@@ -0,0 +1,197 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package app provides the core app functionality. This file, check.go,
+// provides support for validating the merged configuration, and for
+// describing where each effective value came from.
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+
+	"github.com/budney/budget/pipeline"
+)
+
+// Severities of diagnostics
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// A Diagnostic describes one problem with the configuration, and what
+// to do about it.
+type Diagnostic struct {
+	Severity string // SeverityError or SeverityWarning
+	Option   string // The command-line flag name of the option at fault
+	Message  string // What's wrong, and how to fix it
+}
+
+// String formats a diagnostic for printing.
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Severity, d.Option, d.Message)
+}
+
+// A Value describes the effective value of one option.
+type Value struct {
+	Option string // The command-line flag name of the option
+	Value  string // The value, with secrets masked
+	Origin string // Which layer the value came from
+}
+
+// secretOptions lists the options whose values are never printed.
+var secretOptions = map[string]bool{"bank-password": true}
+
+// Values returns the effective value of every option, and the layer it
+// came from. Secrets are masked, and options with no value are reported
+// as "unset".
+func (flags Flags) Values() []Value {
+	var values []Value
+
+	for _, option := range stringOptions {
+		value := *option.field(&flags)
+		if value != "" && secretOptions[option.name] {
+			value = "********"
+		}
+		values = append(values, flags.value(option.name, value))
+	}
+
+	values = append(values, flags.value("account", flags.Bank.Accounts.String()))
+
+	// Only the questions are printed, since the answers are secrets
+	questions := sortedKeys(flags.Bank.SecurityQuestions)
+	values = append(values, flags.value("security-questions", fmt.Sprintf("%q", questions)))
+
+	for _, account := range sortedKeys(flags.Sheets.Worksheets) {
+		values = append(values, flags.value("worksheets", fmt.Sprintf("%s => %s", account, flags.Sheets.Worksheets[account])))
+	}
+	categories := make([]string, 0, len(flags.Targets))
+	for category := range flags.Targets {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		values = append(values, flags.value("targets", fmt.Sprintf("%s => %.2f", category, flags.Targets[category])))
+	}
+
+	return values
+}
+
+// value builds a Value for the named option.
+func (flags Flags) value(option string, value string) Value {
+	origin, ok := flags.Origins[option]
+	if !ok {
+		origin = "unset"
+	}
+
+	return Value{Option: option, Value: value, Origin: origin}
+}
+
+// Check validates the configuration, and returns a list of diagnostics.
+// The configuration is usable if none of them are errors.
+func (flags Flags) Check() []Diagnostic {
+	var diagnostics []Diagnostic
+	report := func(severity, option, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{severity, option, fmt.Sprintf(format, args...)})
+	}
+
+	// The index is where everything starts
+	if flags.Sheets.IndexSheetID == "" {
+		report(SeverityError, "index-sheet-id", "no budget index is configured; set --index-sheet-id, BUDGET_INDEX_SHEET_ID, or Sheets.IndexSheetID in %s", flags.Sheets.ConfigFileName)
+	}
+
+	// Google authentication
+	if err := checkJSONFile(flags.Sheets.AppSecretFile, "installed", "web"); err != nil {
+		report(SeverityError, "app-secret-file", "%v; download the OAuth client ID file from the Google API console", err)
+	}
+	if _, err := os.Stat(flags.Sheets.UserAuthFile); os.IsNotExist(err) {
+		report(SeverityWarning, "user-auth-file", "%s doesn't exist; you'll be asked to authorize the app in a browser on the next run", flags.Sheets.UserAuthFile)
+	} else if err := checkJSONFile(flags.Sheets.UserAuthFile, "access_token", "refresh_token"); err != nil {
+		report(SeverityError, "user-auth-file", "%v; delete it and authorize the app again", err)
+	}
+
+	// Online banking
+	if flags.Bank.LoginURL == "" {
+		report(SeverityError, "bank-url", "no online banking URL is configured")
+	} else if u, err := url.Parse(flags.Bank.LoginURL); err != nil {
+		report(SeverityError, "bank-url", "%v", err)
+	} else if u.Scheme != "https" || u.Host == "" {
+		report(SeverityError, "bank-url", "%q is not an https:// URL", flags.Bank.LoginURL)
+	}
+	password := flags.Bank.Password != "" || flags.Bank.PasswordCommand != "" || flags.Bank.PasswordFile != "" || flags.Bank.PasswordEnv != ""
+	if flags.Bank.Username == "" || !password {
+		if _, err := os.Stat(flags.Bank.VaultFile); os.IsNotExist(err) {
+			report(SeverityError, "bank-password", "no bank login is configured; use a password option or run \"budget-update vault add %s\"", flags.Bank.Institution)
+		}
+	}
+
+	// Every account needs somewhere to go
+	if len(flags.Bank.Accounts) == 0 {
+		report(SeverityWarning, "account", "no accounts are configured, so nothing will be downloaded")
+	}
+	for _, account := range flags.Bank.Accounts {
+		if _, ok := flags.Sheets.Worksheets[account]; !ok {
+			report(SeverityWarning, "worksheets", "account %q has no worksheet mapping; transactions will go to the worksheet named %q", account, account)
+		}
+	}
+	for _, account := range sortedKeys(flags.Sheets.Worksheets) {
+		if !contains(flags.Bank.Accounts, account) {
+			report(SeverityWarning, "worksheets", "worksheet mapping for %q doesn't match any configured account", account)
+		}
+	}
+
+	// Bad stages would stop every sync
+	if _, err := pipeline.FromConfig(flags.Pipeline); err != nil {
+		report(SeverityError, "pipeline", "%v; fix Pipeline in %s", err, flags.Sheets.ConfigFileName)
+	}
+
+	return diagnostics
+}
+
+// checkJSONFile verifies that a file exists, and contains a JSON object
+// with at least one of the specified keys.
+func checkJSONFile(fileName string, keys ...string) error {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	var object map[string]interface{}
+	if err = decodeJSON(b, &object); err != nil {
+		return fmt.Errorf("%s: %v", fileName, err)
+	}
+
+	for _, key := range keys {
+		if _, ok := object[key]; ok {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s doesn't look like the right kind of file", fileName)
+}
+
+// sortedKeys returns the keys of m, sorted.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,210 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package app provides the core app functionality. This file, config.go,
+// provides support for decoding config files written in JSON, YAML or
+// TOML. The format is chosen by the file's extension, and every format
+// is decoded into the same Flags structure.
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+)
+
+// decodeConfig decodes the contents of a config file into flags, choosing
+// the format by the file's extension. Anything that isn't YAML or TOML
+// is treated as JSON.
+//
+// YAML and TOML files are first decoded into generic maps, and then
+// re-encoded as JSON. That way, every format has identical semantics:
+// keys are matched to field names without regard to case, and values
+// are converted exactly as they would be in options.json.
+func decodeConfig(fileName string, b []byte, flags *Flags) error {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		return decodeYAML(b, flags)
+	case ".toml":
+		return decodeTOML(b, flags)
+	default:
+		return decodeJSON(b, flags)
+	}
+}
+
+// decodeJSON decodes a JSON file into v, reporting the line and column
+// of syntax and type errors.
+func decodeJSON(b []byte, v interface{}) error {
+	err := json.Unmarshal(b, v)
+
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		line, col := position(b, e.Offset)
+		return fmt.Errorf("line %d, column %d: %v", line, col, e)
+	case *json.UnmarshalTypeError:
+		line, col := position(b, e.Offset)
+		return fmt.Errorf("line %d, column %d: %v", line, col, e)
+	}
+
+	return err
+}
+
+// decodeYAML decodes a YAML config file. The YAML parser reports line
+// numbers for syntax errors on its own, and type errors are reported at
+// the line of the field they're in.
+func decodeYAML(b []byte, flags *Flags) error {
+	var document yaml.Node
+	if err := yaml.Unmarshal(b, &document); err != nil {
+		return err
+	}
+
+	// An empty file has no content, which is fine
+	if len(document.Content) == 0 {
+		return nil
+	}
+
+	var values interface{}
+	if err := document.Decode(&values); err != nil {
+		return err
+	}
+
+	return decodeGeneric(stringKeys(values), flags, func(field string) int {
+		return yamlLine(document.Content[0], field)
+	})
+}
+
+// decodeTOML decodes a TOML config file. The TOML parser reports line
+// and column numbers for syntax errors on its own, and type errors are
+// reported at the line of the field they're in.
+func decodeTOML(b []byte, flags *Flags) error {
+	tree, err := toml.LoadBytes(b)
+	if err != nil {
+		return err
+	}
+
+	return decodeGeneric(tree.ToMap(), flags, func(field string) int {
+		return tomlLine(tree, field)
+	})
+}
+
+// decodeGeneric converts a generic map, as returned by the YAML and TOML
+// parsers, into flags by way of JSON. Offsets into the re-encoded JSON
+// are meaningless to the user, so type errors report the field that was
+// wrong, and the line lineOf finds it on, if it's not 0.
+func decodeGeneric(values interface{}, flags *Flags, lineOf func(field string) int) error {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(b, flags)
+	if e, ok := err.(*json.UnmarshalTypeError); ok {
+		err = fmt.Errorf("field %s: cannot use %s as %v", e.Field, e.Value, e.Type)
+		if line := lineOf(e.Field); line > 0 {
+			err = fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+
+	return err
+}
+
+// stringKeys converts the keys of YAML maps to strings, recursively, so
+// that they can be encoded as JSON. YAML allows keys of any type, like
+// the number in "2018: rent", and decodes maps that have them as
+// map[interface{}]interface{}.
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, element := range v {
+			v[key] = stringKeys(element)
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			m[fmt.Sprint(key)] = stringKeys(element)
+		}
+		return m
+	case []interface{}:
+		for i, element := range v {
+			v[i] = stringKeys(element)
+		}
+	}
+
+	return value
+}
+
+// yamlLine returns the line of the field, a path of dotted field names
+// from a JSON type error, in a YAML document. Names are matched without
+// regard to case, as JSON does. If the field isn't found, it returns the
+// line of the nearest enclosing field that is, or 0.
+func yamlLine(node *yaml.Node, field string) int {
+	line := 0
+
+	for _, name := range strings.Split(field, ".") {
+		if node.Kind != yaml.MappingNode {
+			break
+		}
+
+		found := false
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if strings.EqualFold(node.Content[i].Value, name) {
+				line = node.Content[i].Line
+				node = node.Content[i+1]
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+
+	return line
+}
+
+// tomlLine is like yamlLine, for a TOML tree.
+func tomlLine(tree *toml.Tree, field string) int {
+	line := 0
+
+	for _, name := range strings.Split(field, ".") {
+		key := ""
+		for _, k := range tree.Keys() {
+			if strings.EqualFold(k, name) {
+				key = k
+				break
+			}
+		}
+		if key == "" {
+			break
+		}
+
+		line = tree.GetPositionPath([]string{key}).Line
+		subtree, ok := tree.GetPath([]string{key}).(*toml.Tree)
+		if !ok {
+			break
+		}
+		tree = subtree
+	}
+
+	return line
+}
+
+// position converts a byte offset into b into a line and column number,
+// both counting from 1.
+func position(b []byte, offset int64) (line int, col int) {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+
+	before := b[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = len(before) - bytes.LastIndexByte(before, '\n')
+
+	return line, col
+}
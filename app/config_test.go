@@ -0,0 +1,99 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+// Every format should decode into the same Flags
+func TestConfigFormats(t *testing.T) {
+	for _, fileName := range []string{"config_test_01.yaml", "config_test_02.toml"} {
+		t.Run(fileName, func(t *testing.T) {
+			flags, err := flagsFromFile(fileName)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if flags.Sheets.IndexSheetID != "index-sheet" {
+				t.Errorf("Wrong IndexSheetID: %q", flags.Sheets.IndexSheetID)
+			}
+			if flags.Bank.LoginURL != "https://bank.example.com/login" {
+				t.Errorf("Wrong LoginURL: %q", flags.Bank.LoginURL)
+			}
+			if len(flags.Bank.Accounts) != 2 || flags.Bank.Accounts[1] != "Savings" {
+				t.Errorf("Wrong accounts: %v", flags.Bank.Accounts)
+			}
+			if flags.Bank.SecurityQuestions["First pet?"] != "Rex" {
+				t.Errorf("Wrong security questions: %v", flags.Bank.SecurityQuestions)
+			}
+		})
+	}
+}
+
+// Syntax errors should report where they happened
+func TestConfigErrorLine(t *testing.T) {
+	_, err := flagsFromFile("config_test_03.json")
+	if err == nil {
+		t.Fatal("Expected an error for a trailing comma")
+	}
+	if !strings.Contains(err.Error(), "line 4") {
+		t.Errorf("Error doesn't report the line: %v", err)
+	}
+}
+
+// Type errors in YAML and TOML files should report their line too
+func TestConfigTypeErrorLine(t *testing.T) {
+	tests := []struct {
+		fileName, config string
+	}{
+		{"options.yaml", "sheets:\n  indexsheetid: index-sheet\n  writesperminute: lots\n"},
+		{"options.toml", "[Sheets]\nIndexSheetID = \"index-sheet\"\nWritesPerMinute = \"lots\"\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fileName, func(t *testing.T) {
+			var flags Flags
+			err := decodeConfig(test.fileName, []byte(test.config), &flags)
+			if err == nil || !strings.Contains(err.Error(), "line 3") {
+				t.Errorf("Expected an error on line 3, got %v", err)
+			}
+		})
+	}
+}
+
+// YAML maps with keys that aren't strings should still decode
+func TestConfigYAMLKeys(t *testing.T) {
+	var flags Flags
+	config := "bank:\n  securityquestions:\n    1999: Springfield\n    true: Yes\n"
+	if err := decodeConfig("options.yaml", []byte(config), &flags); err != nil {
+		t.Fatal(err)
+	}
+	if flags.Bank.SecurityQuestions["1999"] != "Springfield" || flags.Bank.SecurityQuestions["true"] != "Yes" {
+		t.Errorf("Wrong security questions: %v", flags.Bank.SecurityQuestions)
+	}
+}
+
+// Test the offset to line and column conversion
+func TestPosition(t *testing.T) {
+	b := []byte("ab\ncd\nef")
+
+	t.Run("Start", func(t *testing.T) {
+		if line, col := position(b, 0); line != 1 || col != 1 {
+			t.Errorf("Expected 1:1, got %d:%d", line, col)
+		}
+	})
+	t.Run("SecondLine", func(t *testing.T) {
+		if line, col := position(b, 4); line != 2 || col != 2 {
+			t.Errorf("Expected 2:2, got %d:%d", line, col)
+		}
+	})
+	t.Run("PastEnd", func(t *testing.T) {
+		if line, col := position(b, 100); line != 3 || col != 3 {
+			t.Errorf("Expected 3:3, got %d:%d", line, col)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package app provides the core app functionality. This file, dates.go,
+// provides support for the --since and --until options, which accept
+// absolute dates in any format the dateparse library understands, as
+// well as relative forms like "30d" and "last-month".
+package app
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+)
+
+// DateRange holds the --since and --until options, for commands that
+// work on a range of dates.
+type DateRange struct {
+	Since string
+	Until string
+}
+
+// AddFlags adds the --since and --until options to a flag set, with the
+// specified defaults.
+func (r *DateRange) AddFlags(fs *flag.FlagSet, since string, until string) {
+	fs.StringVar(&r.Since, "since", since, "The first `date` to include: a date, or a relative date like 30d, 2w, 6m, this-month, last-month or 2018-01")
+	fs.StringVar(&r.Until, "until", until, "The last `date` to include, in any of the forms accepted by --since")
+}
+
+// Resolve converts the options into a start and end date, relative to
+// now. Both dates are inclusive, and have no time of day.
+func (r DateRange) Resolve(now time.Time) (start time.Time, end time.Time, err error) {
+	if start, err = ParseDate(r.Since, now, false); err != nil {
+		return start, end, fmt.Errorf("--since: %v", err)
+	}
+	if end, err = ParseDate(r.Until, now, true); err != nil {
+		return start, end, fmt.Errorf("--until: %v", err)
+	}
+	if start.After(end) {
+		return start, end, fmt.Errorf("--since %s is after --until %s", start.Format("2006-01-02"), end.Format("2006-01-02"))
+	}
+
+	return start, end, nil
+}
+
+// relativeDate matches relative dates like "30d", i.e. 30 days ago.
+var relativeDate = regexp.MustCompile(`^(\d+)([dwmy])$`)
+
+// yearMonth matches a month, like "2018-01", or a year, like "2018".
+var yearMonth = regexp.MustCompile(`^(\d{4})(?:-(\d{1,2}))?$`)
+
+// ParseDate converts s into a date, relative to now. Periods such as
+// "last-month" or "2018-01" stand for their first day, unless end is
+// true, in which case they stand for their last day. Anything that isn't
+// a relative date is handed to dateparse.
+func ParseDate(s string, now time.Time, end bool) (time.Time, error) {
+	today := dateOf(now)
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	// Pick the first or last day of a period
+	period := func(first time.Time, years, months int) time.Time {
+		if end {
+			return first.AddDate(years, months, -1)
+		}
+		return first
+	}
+	thisMonth := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.Local)
+	thisYear := time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, time.Local)
+
+	switch s {
+	case "":
+		return time.Time{}, fmt.Errorf("no date given")
+	case "today":
+		return today, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	case "this-month":
+		return period(thisMonth, 0, 1), nil
+	case "last-month":
+		return period(thisMonth.AddDate(0, -1, 0), 0, 1), nil
+	case "this-year":
+		return period(thisYear, 1, 0), nil
+	case "last-year":
+		return period(thisYear.AddDate(-1, 0, 0), 1, 0), nil
+	}
+
+	// Amounts of time ago, like "30d" or "6m"
+	if m := relativeDate.FindStringSubmatch(s); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		switch m[2] {
+		case "d":
+			return today.AddDate(0, 0, -n), nil
+		case "w":
+			return today.AddDate(0, 0, -7*n), nil
+		case "m":
+			return today.AddDate(0, -n, 0), nil
+		default:
+			return today.AddDate(-n, 0, 0), nil
+		}
+	}
+
+	// Whole months and years, like "2018-01" or "2018"
+	if m := yearMonth.FindStringSubmatch(s); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		if m[2] == "" {
+			return period(time.Date(year, time.January, 1, 0, 0, 0, 0, time.Local), 1, 0), nil
+		}
+
+		month, _ := strconv.Atoi(m[2])
+		if month < 1 || month > 12 {
+			return time.Time{}, fmt.Errorf("invalid month in %q", s)
+		}
+		return period(time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local), 0, 1), nil
+	}
+
+	// Anything else is an absolute date
+	t, err := dateparse.ParseLocal(s)
+	if err != nil {
+		return t, err
+	}
+
+	return dateOf(t), nil
+}
+
+// dateOf returns the date of t, with the time of day zeroed out.
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
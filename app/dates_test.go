@@ -0,0 +1,71 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+// Test the absolute and relative date forms, as start and end dates
+func TestParseDate(t *testing.T) {
+	now := time.Date(2018, time.March, 15, 13, 45, 0, 0, time.Local)
+	tests := []struct {
+		input string
+		start string
+		end   string
+	}{
+		{"today", "2018-03-15", "2018-03-15"},
+		{"yesterday", "2018-03-14", "2018-03-14"},
+		{"30d", "2018-02-13", "2018-02-13"},
+		{"2w", "2018-03-01", "2018-03-01"},
+		{"1m", "2018-02-15", "2018-02-15"},
+		{"this-month", "2018-03-01", "2018-03-31"},
+		{"last-month", "2018-02-01", "2018-02-28"},
+		{"last-year", "2017-01-01", "2017-12-31"},
+		{"2018-01", "2018-01-01", "2018-01-31"},
+		{"2016", "2016-01-01", "2016-12-31"},
+		{"1/10/2018", "2018-01-10", "2018-01-10"},
+		{"2018-01-10 12:34", "2018-01-10", "2018-01-10"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			start, err := ParseDate(test.input, now, false)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			end, _ := ParseDate(test.input, now, true)
+
+			if start.Format("2006-01-02") != test.start {
+				t.Errorf("Start: expected %s, got %s", test.start, start.Format("2006-01-02"))
+			}
+			if end.Format("2006-01-02") != test.end {
+				t.Errorf("End: expected %s, got %s", test.end, end.Format("2006-01-02"))
+			}
+		})
+	}
+}
+
+// Test that backwards and bogus ranges are rejected
+func TestResolveErrors(t *testing.T) {
+	now := time.Date(2018, time.March, 15, 0, 0, 0, 0, time.Local)
+
+	t.Run("Backwards", func(t *testing.T) {
+		if _, _, err := (DateRange{"today", "30d"}).Resolve(now); err == nil {
+			t.Fail()
+		}
+	})
+	t.Run("BadMonth", func(t *testing.T) {
+		if _, _, err := (DateRange{"2018-13", "today"}).Resolve(now); err == nil {
+			t.Fail()
+		}
+	})
+	t.Run("Gibberish", func(t *testing.T) {
+		if _, _, err := (DateRange{"whenever", "today"}).Resolve(now); err == nil {
+			t.Fail()
+		}
+	})
+}
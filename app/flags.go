@@ -3,33 +3,47 @@
 // license that can be found in the LICENSE file.
 
 // Package app provides the core app functionality. This file, flags.go,
-// provides support for parsing command-line flags and reading a JSON file
-// of runtime options, and merging the two so that options override the
-// config file which overrides the defailts.
+// provides support for parsing command-line flags, reading BUDGET_*
+// environment variables and reading a JSON, YAML or TOML file of runtime
+// options, and merging them so that options override the environment,
+// which overrides the config file, which overrides the defaults.
 package app
 
 import (
-	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
+	"os"
 	"os/user"
 	"path/filepath"
 	"strings"
+
+	"github.com/budney/budget/pipeline"
+	"github.com/budney/budget/retry"
 )
 
 const defaultConfigDir = ".budget-update"    // The defaultConfigDir contains all config files
 const defaultConfigFile = "options.json"     // The defaultConfigFile is read unless overridden
 const defaultSecretFile = "client-auth.json" // The defaultSecretFile contains app authentication
 const defaultAuthFile = "user-auth.json"     // The defaultAuthFile contains user authentication
+const defaultVaultFile = "vault.json"        // The defaultVaultFile contains encrypted bank logins
+const defaultLedgerFile = "ledger.db"        // The defaultLedgerFile contains every transaction downloaded
 const nullString = string(byte(0))           // A string with a null byte
+const envPrefix = "BUDGET_"                  // The envPrefix starts every environment variable we read
 
 // Sheets holds command-line flags related to spreadsheets
 type Sheets struct {
-	IndexSheetID   string
-	ConfigFileName string
-	AppSecretFile  string
-	UserAuthFile   string
+	IndexSheetID    string
+	ConfigFileName  string
+	AppSecretFile   string
+	UserAuthFile    string
+	LedgerFile      string            // The local database of every transaction downloaded
+	Worksheets      map[string]string // Maps account names to worksheet names
+	ChunkSize       int               // The most rows to append in one request
+	WritesPerMinute float64           // The most write requests per minute, across all spreadsheets
+	WriteBurst      int               // The most write requests allowed at once
 }
 
 // Type for holding repeated arguments
@@ -47,9 +61,14 @@ func (i *arrayFlags) Set(value string) error {
 
 // Bank holds command-line flags related to web banking
 type Bank struct {
+	Institution       string // The name of the bank's entry in the vault
+	VaultFile         string // The encrypted vault of bank logins
 	LoginURL          string
 	Username          string
 	Password          string
+	PasswordCommand   string // A shell command that prints the password
+	PasswordFile      string // A file, readable only by its owner, holding the password
+	PasswordEnv       string // An environment variable holding the password
 	Accounts          arrayFlags
 	SecurityQuestions map[string]string
 }
@@ -58,34 +77,104 @@ type Bank struct {
 type Flags struct {
 	Sheets Sheets
 	Bank   Bank
+	Retry  retry.Policy // How to retry Google Sheets calls that fail
+
+	// Pipeline lists the stages transactions pass through on their way
+	// from the bank to the budget.
+	Pipeline []pipeline.Config
+
+	// Targets maps budget categories to the amount budgeted for them
+	// each month, in dollars. A Budget worksheet in a spreadsheet
+	// overrides them for that spreadsheet's period.
+	Targets map[string]float64
+
+	// Origins records which layer each option's value came from, keyed
+	// by the option's command-line flag name.
+	Origins map[string]string `json:"-"`
 }
 
-// readCommandLine uses the flag package to configure command-line options.
-func readCommandLine(flags Flags) {
+// readCommandLine adds the shared command-line options to a flag set,
+// and parses args with it.
+func readCommandLine(fs *flag.FlagSet, args []string, flags *Flags) error {
 	// Configure command-line options
-	flag.StringVar(&flags.Sheets.IndexSheetID, "index-sheet-id", nullString, "Google drive `sheet-id` of the budget index")
-	flag.StringVar(&flags.Sheets.ConfigFileName, "config-file", nullString, "The `filename` of the config file to read at startup")
-	flag.StringVar(&flags.Sheets.AppSecretFile, "app-secret-file", nullString, "The `filename` for the app to authenticate with Google Drive")
-	flag.StringVar(&flags.Sheets.UserAuthFile, "user-auth-file", nullString, "The `filename` with cached user credentials for Google Drive")
-	flag.StringVar(&flags.Bank.LoginURL, "bank-url", nullString, "The `URL` of the online banking web page")
-	flag.StringVar(&flags.Bank.Username, "bank-username", nullString, "Your online banking `username`")
-	flag.StringVar(&flags.Bank.Password, "bank-password", nullString, "Your online banking `password`")
-	flag.Var(&flags.Bank.Accounts, "account", "Name(s) of account(s) to download transactions for")
+	fs.StringVar(&flags.Sheets.IndexSheetID, "index-sheet-id", nullString, "Google drive `sheet-id` of the budget index")
+	fs.StringVar(&flags.Sheets.ConfigFileName, "config-file", nullString, "The `filename` of the config file to read at startup")
+	fs.StringVar(&flags.Sheets.AppSecretFile, "app-secret-file", nullString, "The `filename` for the app to authenticate with Google Drive")
+	fs.StringVar(&flags.Sheets.UserAuthFile, "user-auth-file", nullString, "The `filename` with cached user credentials for Google Drive")
+	fs.StringVar(&flags.Sheets.LedgerFile, "ledger-file", nullString, "The `filename` of the local database of downloaded transactions")
+	fs.StringVar(&flags.Bank.Institution, "institution", nullString, "The `name` of the bank's entry in the vault (default: the bank URL's host)")
+	fs.StringVar(&flags.Bank.VaultFile, "vault-file", nullString, "The `filename` of the encrypted vault of bank logins")
+	fs.StringVar(&flags.Bank.LoginURL, "bank-url", nullString, "The `URL` of the online banking web page")
+	fs.StringVar(&flags.Bank.Username, "bank-username", nullString, "Your online banking `username`")
+	fs.StringVar(&flags.Bank.Password, "bank-password", nullString, "Your online banking `password` (visible to other users; prefer the options below)")
+	fs.StringVar(&flags.Bank.PasswordCommand, "bank-password-command", nullString, "A shell `command` that prints your online banking password")
+	fs.StringVar(&flags.Bank.PasswordFile, "bank-password-file", nullString, "The `filename` of a file holding your online banking password")
+	fs.StringVar(&flags.Bank.PasswordEnv, "bank-password-env", nullString, "The `name` of an environment variable holding your online banking password")
+	fs.Var(&flags.Bank.Accounts, "account", "Name(s) of account(s) to download transactions for")
 
 	// Parse the command line
-	flag.Parse()
+	return fs.Parse(args)
+}
+
+// readEnvironment reads the BUDGET_* environment variables into a Flags
+// record. Variables that aren't set are left as nullString, so that
+// copyOptions can tell them apart from variables set to "".
+func readEnvironment() Flags {
+	var env Flags
+
+	env.Sheets.IndexSheetID = getenv("INDEX_SHEET_ID")
+	env.Sheets.ConfigFileName = getenv("CONFIG_FILE")
+	env.Sheets.AppSecretFile = getenv("APP_SECRET_FILE")
+	env.Sheets.UserAuthFile = getenv("USER_AUTH_FILE")
+	env.Sheets.LedgerFile = getenv("LEDGER_FILE")
+	env.Bank.Institution = getenv("INSTITUTION")
+	env.Bank.VaultFile = getenv("VAULT_FILE")
+	env.Bank.LoginURL = getenv("BANK_URL")
+	env.Bank.Username = getenv("BANK_USERNAME")
+	env.Bank.Password = getenv("BANK_PASSWORD")
+	env.Bank.PasswordCommand = getenv("BANK_PASSWORD_COMMAND")
+	env.Bank.PasswordFile = getenv("BANK_PASSWORD_FILE")
+	env.Bank.PasswordEnv = getenv("BANK_PASSWORD_ENV")
+
+	// Accounts are a comma-separated list, e.g. "Checking, Savings"
+	if accounts := getenv("ACCOUNTS"); accounts != nullString {
+		for _, account := range strings.Split(accounts, ",") {
+			if account = strings.TrimSpace(account); account != "" {
+				env.Bank.Accounts = append(env.Bank.Accounts, account)
+			}
+		}
+	}
+
+	return env
+}
+
+// getenv returns the value of the environment variable envPrefix+name,
+// or nullString if the variable isn't set.
+func getenv(name string) string {
+	if value, ok := os.LookupEnv(envPrefix + name); ok {
+		return value
+	}
+
+	return nullString
 }
 
 // readConfigFile reads the config file specified in flags, or else the
-// default.
-func readConfigFile(flags Flags) Flags {
+// one specified in the environment, or else the default. It's an error
+// for a config file that was specified not to exist, but the default
+// one is optional: without it, the config file sets nothing.
+func readConfigFile(flags Flags, env Flags) (Flags, error) {
 	var configFile string
 
 	// Read the defaults file, if any
 	if flags.Sheets.ConfigFileName != "" && flags.Sheets.ConfigFileName != nullString {
 		configFile = flags.Sheets.ConfigFileName
+	} else if env.Sheets.ConfigFileName != "" && env.Sheets.ConfigFileName != nullString {
+		configFile = env.Sheets.ConfigFileName
 	} else {
 		configFile = defaultPath(defaultConfigFile)
+		if _, err := os.Stat(configFile); os.IsNotExist(err) {
+			return Flags{}, nil
+		}
 	}
 
 	// Read the configs from a file, and then overwrite with options
@@ -93,56 +182,146 @@ func readConfigFile(flags Flags) Flags {
 	return flagsFromFile(configFile)
 }
 
-func copyOptions(src Flags, dest Flags) {
-	// Copy spreadsheet options
-	if src.Sheets.IndexSheetID != nullString {
-		dest.Sheets.IndexSheetID = src.Sheets.IndexSheetID
+// Origins of option values, as reported by Flags.Origins
+const (
+	OriginDefault = "default"
+	OriginFile    = "file"
+	OriginEnv     = "environment"
+	OriginFlag    = "flag"
+)
+
+// stringOptions lists every string option by its command-line flag
+// name, along with a function that locates it in a Flags record.
+var stringOptions = []struct {
+	name  string
+	field func(*Flags) *string
+}{
+	{"index-sheet-id", func(f *Flags) *string { return &f.Sheets.IndexSheetID }},
+	{"config-file", func(f *Flags) *string { return &f.Sheets.ConfigFileName }},
+	{"app-secret-file", func(f *Flags) *string { return &f.Sheets.AppSecretFile }},
+	{"user-auth-file", func(f *Flags) *string { return &f.Sheets.UserAuthFile }},
+	{"ledger-file", func(f *Flags) *string { return &f.Sheets.LedgerFile }},
+	{"institution", func(f *Flags) *string { return &f.Bank.Institution }},
+	{"vault-file", func(f *Flags) *string { return &f.Bank.VaultFile }},
+	{"bank-url", func(f *Flags) *string { return &f.Bank.LoginURL }},
+	{"bank-username", func(f *Flags) *string { return &f.Bank.Username }},
+	{"bank-password", func(f *Flags) *string { return &f.Bank.Password }},
+	{"bank-password-command", func(f *Flags) *string { return &f.Bank.PasswordCommand }},
+	{"bank-password-file", func(f *Flags) *string { return &f.Bank.PasswordFile }},
+	{"bank-password-env", func(f *Flags) *string { return &f.Bank.PasswordEnv }},
+}
+
+// copyOptions copies every option that was set in src over the
+// corresponding option in dest, and records where it came from.
+func copyOptions(src Flags, dest *Flags, origin string) {
+	for _, option := range stringOptions {
+		if value := *option.field(&src); value != nullString {
+			*option.field(dest) = value
+			dest.setOrigin(option.name, origin)
+		}
 	}
-	if src.Sheets.ConfigFileName != nullString {
-		dest.Sheets.ConfigFileName = src.Sheets.ConfigFileName
+
+	// Copy the list of accounts
+	if len(src.Bank.Accounts) > 0 {
+		dest.Bank.Accounts = src.Bank.Accounts
+		dest.setOrigin("account", origin)
 	}
-	if src.Sheets.AppSecretFile != nullString {
-		dest.Sheets.AppSecretFile = src.Sheets.AppSecretFile
+}
+
+// fileOrigins records that every option with a value came from the
+// config file. It must be called before any other layer is merged.
+func fileOrigins(options *Flags) {
+	for _, option := range stringOptions {
+		if *option.field(options) != "" {
+			options.setOrigin(option.name, OriginFile)
+		}
 	}
 
-	// Copy bank options
-	if src.Bank.LoginURL != nullString {
-		dest.Bank.LoginURL = src.Bank.LoginURL
+	if len(options.Bank.Accounts) > 0 {
+		options.setOrigin("account", OriginFile)
 	}
-	if src.Bank.Username != nullString {
-		dest.Bank.Username = src.Bank.Username
+	if len(options.Bank.SecurityQuestions) > 0 {
+		options.setOrigin("security-questions", OriginFile)
 	}
-	if src.Bank.Password != nullString {
-		dest.Bank.Password = src.Bank.Password
+	if len(options.Sheets.Worksheets) > 0 {
+		options.setOrigin("worksheets", OriginFile)
 	}
+	if len(options.Targets) > 0 {
+		options.setOrigin("targets", OriginFile)
+	}
+}
 
-	// Copy the list of accounts
-	if len(src.Bank.Accounts) > 0 {
-		dest.Bank.Accounts = src.Bank.Accounts
+// setDefault sets an option to its default value, if it has no value.
+func (flags *Flags) setDefault(name string, value *string, def string) {
+	if *value == "" {
+		*value = def
+		flags.setOrigin(name, OriginDefault)
 	}
 }
 
-// ParseFlags parses command-line flags
-func ParseFlags() Flags {
+// setOrigin records where the named option's value came from.
+func (flags *Flags) setOrigin(name string, origin string) {
+	if flags.Origins == nil {
+		flags.Origins = make(map[string]string)
+	}
+
+	flags.Origins[name] = origin
+}
+
+// Worksheet returns the name of the worksheet that transactions from
+// the named account are written to. Unless the config file says
+// otherwise, that's the worksheet with the same name as the account.
+func (flags Flags) Worksheet(account string) string {
+	if worksheet, ok := flags.Sheets.Worksheets[account]; ok {
+		return worksheet
+	}
+
+	return account
+}
+
+// ParseFlags parses the program's command-line flags, the environment
+// and the config file, and merges them. It returns an error if the
+// config file can't be read or decoded.
+func ParseFlags() (Flags, error) {
+	return ParseFlagSet(flag.CommandLine, os.Args[1:])
+}
+
+// ParseFlagSet is like ParseFlags, but parses args using the specified
+// flag set. The shared options are added to the flag set, so that every
+// subcommand accepts them alongside its own options; after parsing,
+// fs.Args() holds the remaining arguments.
+func ParseFlagSet(fs *flag.FlagSet, args []string) (Flags, error) {
 	// Read the command line flags. We have to do this
 	// first, in case they specify a different config file.
 	var flags Flags
-	readCommandLine(flags)
+	if err := readCommandLine(fs, args, &flags); err != nil {
+		return flags, err
+	}
 
-	// Read the config file flags, and replace them with
-	// any command-line flags we received.
-	var options = readConfigFile(flags)
-	copyOptions(flags, options)
+	// Read the environment, which can also specify the config file
+	env := readEnvironment()
 
-	// Now set default authorization values, if they weren't already set
-	if options.Sheets.AppSecretFile == "" {
-		options.Sheets.AppSecretFile = defaultPath(defaultSecretFile)
+	// Read the config file flags, and replace them with any
+	// environment variables and then command-line flags we received.
+	options, err := readConfigFile(flags, env)
+	if err != nil {
+		return options, err
 	}
-	if options.Sheets.UserAuthFile == "" {
-		options.Sheets.UserAuthFile = defaultPath(defaultAuthFile)
+	fileOrigins(&options)
+	copyOptions(env, &options, OriginEnv)
+	copyOptions(flags, &options, OriginFlag)
+
+	// Now set default values, if they weren't already set
+	options.setDefault("config-file", &options.Sheets.ConfigFileName, defaultPath(defaultConfigFile))
+	options.setDefault("app-secret-file", &options.Sheets.AppSecretFile, defaultPath(defaultSecretFile))
+	options.setDefault("user-auth-file", &options.Sheets.UserAuthFile, defaultPath(defaultAuthFile))
+	options.setDefault("ledger-file", &options.Sheets.LedgerFile, defaultPath(defaultLedgerFile))
+	options.setDefault("vault-file", &options.Bank.VaultFile, defaultPath(defaultVaultFile))
+	if u, err := url.Parse(options.Bank.LoginURL); err == nil {
+		options.setDefault("institution", &options.Bank.Institution, u.Hostname())
 	}
 
-	return options
+	return options, nil
 }
 
 // defaultPath takes the specified filename, and converts it into
@@ -159,19 +338,20 @@ func defaultPath(fileName string) string {
 	return filepath.Join(usr.HomeDir, defaultConfigDir, filepath.Clean(fileName))
 }
 
-// flagsFromFile reads the specified file and converts it into a Flags record.
-func flagsFromFile(fileName string) Flags {
+// flagsFromFile reads the specified file and converts it into a Flags
+// record. The file may be JSON, YAML or TOML, depending on its extension.
+func flagsFromFile(fileName string) (Flags, error) {
 	flags := Flags{}
 
 	b, err := ioutil.ReadFile(fileName)
 	if err != nil {
-		log.Fatalf("Unable to read config file: %v", err)
+		return flags, fmt.Errorf("unable to read config file: %v", err)
 	}
 
-	err = json.Unmarshal(b, &flags)
+	err = decodeConfig(fileName, b, &flags)
 	if err != nil {
-		log.Fatalf("Unable to process config file: %v", err)
+		return flags, fmt.Errorf("unable to process config file %s: %v", fileName, err)
 	}
 
-	return flags
+	return flags, nil
 }
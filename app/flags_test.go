@@ -121,13 +121,87 @@ func TestParseAccounts(t *testing.T) {
 	}
 }
 
+// Test that each layer overrides the ones below it, and is recorded
+func TestParseLayers(t *testing.T) {
+	os.Setenv("BUDGET_BANK_URL", "https://env.example.com/")
+	os.Setenv("BUDGET_BANK_USERNAME", "env-user")
+	defer os.Unsetenv("BUDGET_BANK_URL")
+	defer os.Unsetenv("BUDGET_BANK_USERNAME")
+
+	os.Args = []string{os.Args[0], "--config-file", "flags_test_01.json", "--bank-username", "flag-user"}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+	flags, err := ParseFlags()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	t.Run("Environment", func(t *testing.T) {
+		if flags.Bank.LoginURL != "https://env.example.com/" || flags.Origins["bank-url"] != OriginEnv {
+			t.Errorf("Got %q from %q", flags.Bank.LoginURL, flags.Origins["bank-url"])
+		}
+	})
+	t.Run("Flag", func(t *testing.T) {
+		if flags.Bank.Username != "flag-user" || flags.Origins["bank-username"] != OriginFlag {
+			t.Errorf("Got %q from %q", flags.Bank.Username, flags.Origins["bank-username"])
+		}
+	})
+	t.Run("Default", func(t *testing.T) {
+		if flags.Bank.Institution != "env.example.com" || flags.Origins["institution"] != OriginDefault {
+			t.Errorf("Got %q from %q", flags.Bank.Institution, flags.Origins["institution"])
+		}
+	})
+}
+
+// Test that flags beat the environment, which beats the config file,
+// which beats the defaults
+func TestParseLayerOrder(t *testing.T) {
+	os.Setenv("BUDGET_BANK_URL", "https://env.example.com/")
+	os.Setenv("BUDGET_BANK_USERNAME", "env-user")
+	defer os.Unsetenv("BUDGET_BANK_URL")
+	defer os.Unsetenv("BUDGET_BANK_USERNAME")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags, err := ParseFlagSet(fs, []string{"--config-file", "flags_test_02.json", "--bank-username", "flag-user"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, want := range []struct {
+		option string
+		value  string
+		origin string
+	}{
+		{"bank-username", flags.Bank.Username, OriginFlag},
+		{"bank-url", flags.Bank.LoginURL, OriginEnv},
+		{"index-sheet-id", flags.Sheets.IndexSheetID, OriginFile},
+		{"institution", flags.Bank.Institution, OriginDefault},
+	} {
+		if flags.Origins[want.option] != want.origin {
+			t.Errorf("Expected %s from %s, got %q from %s", want.option, want.origin, want.value, flags.Origins[want.option])
+		}
+	}
+	if flags.Bank.Username != "flag-user" || flags.Bank.LoginURL != "https://env.example.com/" ||
+		flags.Sheets.IndexSheetID != "file-index" || flags.Bank.Institution != "env.example.com" {
+		t.Errorf("Wrong values: %+v", flags)
+	}
+}
+
+// A config file that was asked for has to exist
+func TestParseMissingConfig(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if _, err := ParseFlagSet(fs, []string{"--config-file", "no_such_file.json"}); err == nil {
+		t.Error("Expected an error for a missing config file")
+	}
+}
+
 // Utility to compare two configs. Quick 'n dirty: only checks
 // that the account lists and security questions are the same length
 func isSame(a, b Flags) bool {
 	if &a == &b {
 		return true
 	}
-	if a.Sheets.IndexSheetId != b.Sheets.IndexSheetId {
+	if a.Sheets.IndexSheetID != b.Sheets.IndexSheetID {
 		return false
 	}
 	if a.Sheets.ConfigFileName != b.Sheets.ConfigFileName {
@@ -139,7 +213,7 @@ func isSame(a, b Flags) bool {
 	if a.Sheets.UserAuthFile != b.Sheets.UserAuthFile {
 		return false
 	}
-	if a.Bank.LoginUrl != b.Bank.LoginUrl {
+	if a.Bank.LoginURL != b.Bank.LoginURL {
 		return false
 	}
 	if a.Bank.Username != b.Bank.Username {
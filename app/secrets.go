@@ -0,0 +1,151 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package app provides the core app functionality. This file, secrets.go,
+// provides support for keeping the bank password out of the config file
+// and off the command line, by looking it up from a helper command, a
+// private file, or an environment variable.
+//
+// Errors from this file never include the password, or the output of
+// the helper command, so they are safe to log.
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ResolvePassword looks up the bank password, if it's kept somewhere
+// safer than the config file. It isn't done when the flags are parsed,
+// so that only commands that log in to the bank run the password command
+// or read the password file.
+func (flags *Flags) ResolvePassword() error {
+	source, err := resolvePassword(&flags.Bank, flags.Origins)
+	if err != nil {
+		return err
+	}
+	if source != "" {
+		flags.setOrigin("bank-password", "via "+source)
+	}
+
+	return nil
+}
+
+// originRanks ranks the layers options come from, so that a password
+// source from a higher layer beats one from a lower layer.
+var originRanks = map[string]int{OriginDefault: 0, OriginFile: 1, OriginEnv: 2, OriginFlag: 3}
+
+// resolvePassword fills in bank.Password from a password source. A
+// password given directly always wins. Otherwise, of the command, file
+// and environment variable sources that are configured, the one set in
+// the highest layer, by origins, is used: one given as a flag beats one
+// from the environment, which beats one from the config file. Of
+// sources from the same layer, the first in that order is used. It's
+// not an error for no password to be configured at all.
+//
+// It returns the name of the option the password was looked up from,
+// or "" if the password was given directly or not at all.
+func resolvePassword(bank *Bank, origins map[string]string) (source string, err error) {
+	if bank.Password != "" {
+		return "", nil
+	}
+
+	sources := []struct {
+		name   string
+		value  string
+		lookup func(string) (string, error)
+	}{
+		{"bank-password-command", bank.PasswordCommand, passwordFromCommand},
+		{"bank-password-file", bank.PasswordFile, passwordFromFile},
+		{"bank-password-env", bank.PasswordEnv, passwordFromEnv},
+	}
+
+	best := -1
+	for i, option := range sources {
+		if option.value == "" {
+			continue
+		}
+		if best < 0 || originRanks[origins[option.name]] > originRanks[origins[sources[best].name]] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", nil
+	}
+
+	bank.Password, err = sources[best].lookup(sources[best].value)
+	return sources[best].name, err
+}
+
+// passwordFromCommand runs a command such as "pass show bank/td" in the
+// shell, and returns the first line of its output. The command's stderr
+// and stdin are the user's terminal, so that it can prompt for a
+// passphrase if it needs to.
+func passwordFromCommand(command string) (string, error) {
+	var stdout bytes.Buffer
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("password command failed: %v", err)
+	}
+
+	password := firstLine(stdout.String())
+	if password == "" {
+		return "", fmt.Errorf("password command printed nothing")
+	}
+
+	return password, nil
+}
+
+// passwordFromFile returns the first line of the specified file. The
+// file must not be readable or writable by anyone but its owner.
+func passwordFromFile(fileName string) (string, error) {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return "", fmt.Errorf("password file: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode&0077 != 0 {
+		return "", fmt.Errorf("password file %s has mode %04o; it must not be accessible by group or others", fileName, mode)
+	}
+
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", fmt.Errorf("password file: %v", err)
+	}
+
+	password := firstLine(string(b))
+	if password == "" {
+		return "", fmt.Errorf("password file %s is empty", fileName)
+	}
+
+	return password, nil
+}
+
+// passwordFromEnv returns the value of the named environment variable.
+func passwordFromEnv(name string) (string, error) {
+	password, ok := os.LookupEnv(name)
+	if !ok || password == "" {
+		return "", fmt.Errorf("password environment variable %s is not set", name)
+	}
+
+	return password, nil
+}
+
+// firstLine returns s up to the first newline, without the newline or
+// a carriage return preceding it.
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+
+	return strings.TrimSuffix(s, "\r")
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test each of the password sources
+func TestResolvePassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, "private")
+	ioutil.WriteFile(private, []byte("hunter2\n"), 0600)
+	public := filepath.Join(dir, "public")
+	ioutil.WriteFile(public, []byte("hunter2\n"), 0644)
+	os.Setenv("BUDGET_TEST_PASSWORD", "hunter2")
+	defer os.Unsetenv("BUDGET_TEST_PASSWORD")
+
+	t.Run("Plaintext", func(t *testing.T) {
+		bank := Bank{Password: "hunter2", PasswordCommand: "false"}
+		if _, err := resolvePassword(&bank, nil); err != nil || bank.Password != "hunter2" {
+			t.Errorf("Got %q, %v", bank.Password, err)
+		}
+	})
+	t.Run("Command", func(t *testing.T) {
+		bank := Bank{PasswordCommand: "printf 'hunter2\\nother stuff\\n'"}
+		if _, err := resolvePassword(&bank, nil); err != nil || bank.Password != "hunter2" {
+			t.Errorf("Got %q, %v", bank.Password, err)
+		}
+	})
+	t.Run("FailedCommand", func(t *testing.T) {
+		bank := Bank{PasswordCommand: "false"}
+		if _, err := resolvePassword(&bank, nil); err == nil {
+			t.Fail()
+		}
+	})
+	t.Run("File", func(t *testing.T) {
+		bank := Bank{PasswordFile: private}
+		if _, err := resolvePassword(&bank, nil); err != nil || bank.Password != "hunter2" {
+			t.Errorf("Got %q, %v", bank.Password, err)
+		}
+	})
+	t.Run("PublicFile", func(t *testing.T) {
+		bank := Bank{PasswordFile: public}
+		_, err := resolvePassword(&bank, nil)
+		if err == nil {
+			t.Fatal("Expected an error for a world-readable file")
+		}
+		if strings.Contains(err.Error(), "hunter2") {
+			t.Errorf("Error leaks the password: %v", err)
+		}
+	})
+	t.Run("Env", func(t *testing.T) {
+		bank := Bank{PasswordEnv: "BUDGET_TEST_PASSWORD"}
+		if _, err := resolvePassword(&bank, nil); err != nil || bank.Password != "hunter2" {
+			t.Errorf("Got %q, %v", bank.Password, err)
+		}
+	})
+	t.Run("Layers", func(t *testing.T) {
+		bank := Bank{PasswordCommand: "false", PasswordEnv: "BUDGET_TEST_PASSWORD"}
+		origins := map[string]string{"bank-password-command": OriginFile, "bank-password-env": OriginFlag}
+		if source, err := resolvePassword(&bank, origins); err != nil || bank.Password != "hunter2" || source != "bank-password-env" {
+			t.Errorf("Got %q from %s, %v", bank.Password, source, err)
+		}
+	})
+	t.Run("Origin", func(t *testing.T) {
+		flags := Flags{Bank: Bank{PasswordEnv: "BUDGET_TEST_PASSWORD"}}
+		if err := flags.ResolvePassword(); err != nil || flags.Origins["bank-password"] != "via bank-password-env" {
+			t.Errorf("Got origin %q, %v", flags.Origins["bank-password"], err)
+		}
+	})
+}
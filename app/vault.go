@@ -0,0 +1,96 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package app provides the core app functionality. This file, vault.go,
+// provides support for unlocking the encrypted vault of bank logins, and
+// for filling in the bank options from it.
+package app
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/budney/budget/vault"
+	"golang.org/x/term"
+)
+
+// VaultPassphrase returns the vault passphrase from the environment
+// variable BUDGET_VAULT_PASSPHRASE, or else prompts for it on the
+// terminal. If confirm is true, the user is asked to type it twice, as
+// they should be when creating a vault.
+func VaultPassphrase(confirm bool) ([]byte, error) {
+	if passphrase := getenv("VAULT_PASSPHRASE"); passphrase != nullString {
+		return []byte(passphrase), nil
+	}
+
+	passphrase, err := ReadSecret("Vault passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+
+	if confirm {
+		again, err := ReadSecret("Repeat passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if string(again) != string(passphrase) {
+			return nil, fmt.Errorf("passphrases don't match")
+		}
+	}
+
+	return passphrase, nil
+}
+
+// OpenVault prompts for the passphrase and opens the vault named in the
+// bank options.
+func OpenVault(bank Bank, confirm bool) (*vault.Vault, error) {
+	passphrase, err := VaultPassphrase(confirm)
+	if err != nil {
+		return nil, err
+	}
+
+	return vault.Open(bank.VaultFile, passphrase)
+}
+
+// UseVault fills in the username, password and security questions from
+// the bank's vault entry, unless they were already configured some other
+// way. It does nothing if there's no vault file, so that users who don't
+// use a vault are never asked for a passphrase.
+func UseVault(bank *Bank) error {
+	if bank.Password != "" {
+		return nil
+	}
+	if _, err := os.Stat(bank.VaultFile); os.IsNotExist(err) {
+		return nil
+	}
+
+	v, err := OpenVault(*bank, false)
+	if err != nil {
+		return err
+	}
+
+	entry, ok := v.Lookup(bank.Institution)
+	if !ok {
+		return fmt.Errorf("no vault entry for %q", bank.Institution)
+	}
+
+	bank.Password = entry.Password
+	if bank.Username == "" {
+		bank.Username = entry.Username
+	}
+	if len(bank.SecurityQuestions) == 0 {
+		bank.SecurityQuestions = entry.SecurityQuestions
+	}
+
+	return nil
+}
+
+// ReadSecret prints a prompt on stderr and reads a line from the
+// terminal without echoing it.
+func ReadSecret(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	return term.ReadPassword(int(os.Stdin.Fd()))
+}
@@ -0,0 +1,276 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package budget
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"google.golang.org/api/sheets/v4"
+)
+
+// A RowError reports a worksheet row that couldn't be read as a
+// transaction.
+type RowError struct {
+	Worksheet string // The worksheet the row is on
+	Row       int    // The row number, as the spreadsheet shows it
+	Err       error  // What was wrong with it
+}
+
+// Error describes the row and what was wrong with it.
+func (e RowError) Error() string {
+	return fmt.Sprintf("%s row %d: %v", e.Worksheet, e.Row, e.Err)
+}
+
+// RowErrors lists every row that couldn't be read from a worksheet.
+type RowErrors []RowError
+
+// Error summarizes the errors, giving the first one in full.
+func (e RowErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	return fmt.Sprintf("%v (and %d more bad rows)", e[0], len(e)-1)
+}
+
+// ReadTransactions reads the transactions in a worksheet, in the order
+// of its rows. It's the inverse of AppendArray: each row's category is
+// read into the transaction's Category. The transactions' Account isn't
+// known, so it's left empty.
+//
+// Blank rows are skipped. Rows that can't be read are skipped too, and
+// reported together in a RowErrors, after all the rows are read; the
+// transactions from the other rows are still returned.
+func (spreadsheet *Spreadsheet) ReadTransactions(ctx context.Context, worksheet string) ([]Transaction, error) {
+	area := worksheet + "!" + DataRange
+
+	var response *sheets.ValueRange
+	err := spreadsheet.Retry.Do(ctx, "Read "+area, func() error {
+		var err error
+		response, err = spreadsheet.Spreadsheets.Values.Get(spreadsheet.SpreadsheetID, area).
+			ValueRenderOption("UNFORMATTED_VALUE").DateTimeRenderOption("SERIAL_NUMBER").Do()
+		return err
+	})
+	if err != nil {
+		log.Printf("Unable to read %s from %s: %v", area, spreadsheet.Filename, err)
+		return nil, err
+	}
+	if response == nil {
+		return nil, nil
+	}
+
+	transactions, _, rowErrors := fromRows(worksheet, response.Values)
+	if len(rowErrors) > 0 {
+		log.Printf("%s: %s: skipped %d bad rows: %v", spreadsheet.Filename, worksheet, len(rowErrors), rowErrors)
+		return transactions, rowErrors
+	}
+
+	return transactions, nil
+}
+
+// fromRows converts the rows of a worksheet's DataRange into
+// transactions, skipping blank rows and reporting the rows that can't be
+// read. It also returns the index in rows of each transaction.
+func fromRows(worksheet string, rows [][]interface{}) ([]Transaction, []int, RowErrors) {
+	var transactions []Transaction
+	var indexes []int
+	var rowErrors RowErrors
+	for i, row := range rows {
+		if blankRow(row) {
+			continue
+		}
+
+		transaction, err := FromSpreadsheetRow(row)
+		if err != nil {
+			// DataRange starts on the second row
+			rowErrors = append(rowErrors, RowError{Worksheet: worksheet, Row: i + 2, Err: err})
+			continue
+		}
+		transactions = append(transactions, transaction)
+		indexes = append(indexes, i)
+	}
+
+	return transactions, indexes, rowErrors
+}
+
+// Recategorize reads the transactions in a worksheet, as ReadTransactions
+// does, and passes each to categorize, which reports whether it changed
+// the transaction's category. If any changed, the worksheet's Category
+// column is written back, with the other rows' categories as they were.
+// It returns how many categories changed. Rows that can't be read are
+// left alone, and reported in a RowErrors once the others are written.
+func (spreadsheet *Spreadsheet) Recategorize(ctx context.Context, worksheet string, categorize func(*Transaction) bool) (int, error) {
+	rows, err := spreadsheet.readRows(ctx, worksheet)
+	if err != nil {
+		log.Printf("Unable to read %s from %s: %v", worksheet, spreadsheet.Filename, err)
+		return 0, err
+	}
+
+	column := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		column[i] = []interface{}{""}
+		if len(row) > 0 {
+			column[i][0] = row[0]
+		}
+	}
+
+	changed := 0
+	transactions, indexes, rowErrors := fromRows(worksheet, rows)
+	for i := range transactions {
+		if categorize(&transactions[i]) {
+			column[indexes[i]][0] = transactions[i].Category
+			changed++
+		}
+	}
+
+	if changed > 0 {
+		// DataRange starts on the second row
+		area := fmt.Sprintf("%s!A2:A%d", worksheet, len(rows)+1)
+		err = spreadsheet.Retry.Do(ctx, "Write "+area, func() error {
+			if err := spreadsheet.waitForQuota(ctx); err != nil {
+				return err
+			}
+
+			valueRange := &sheets.ValueRange{Range: area, MajorDimension: "ROWS", Values: column}
+			_, err := spreadsheet.Spreadsheets.Values.Update(spreadsheet.SpreadsheetID, area, valueRange).ValueInputOption("USER_ENTERED").Do()
+			return err
+		})
+		if err != nil {
+			log.Printf("Couldn't write %s: %s", area, err)
+			return 0, err
+		}
+	}
+
+	if len(rowErrors) > 0 {
+		log.Printf("%s: %s: skipped %d bad rows: %v", spreadsheet.Filename, worksheet, len(rowErrors), rowErrors)
+		return changed, rowErrors
+	}
+
+	return changed, nil
+}
+
+// FromSpreadsheetRow converts a worksheet row, in the column order of
+// transactionRow, into a transaction. Cells may hold unformatted values,
+// where dates are serial numbers, or text typed in by hand, such as
+// "1/2/2006" or "$1,234.56".
+func FromSpreadsheetRow(row []interface{}) (Transaction, error) {
+	var transaction Transaction
+	var err error
+
+	cell := func(i int) interface{} {
+		if i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	transaction.Category = cellString(cell(0))
+	if transaction.Index, err = cellInt(cell(1)); err != nil {
+		return transaction, fmt.Errorf("index: %v", err)
+	}
+	if transaction.Date, err = cellDate(cell(2)); err != nil {
+		return transaction, fmt.Errorf("date: %v", err)
+	}
+	transaction.Type = cellString(cell(3))
+	transaction.Description = cellString(cell(4))
+	if transaction.DebitPennies, err = cellPennies(cell(5)); err != nil {
+		return transaction, fmt.Errorf("debit: %v", err)
+	}
+	if transaction.CreditPennies, err = cellPennies(cell(6)); err != nil {
+		return transaction, fmt.Errorf("credit: %v", err)
+	}
+	if transaction.BalancePennies, err = cellPennies(cell(7)); err != nil {
+		return transaction, fmt.Errorf("balance: %v", err)
+	}
+
+	return transaction, nil
+}
+
+// blankRow reports whether every cell of a row is empty.
+func blankRow(row []interface{}) bool {
+	for _, cell := range row {
+		if cellString(cell) != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// cellString returns a cell's value as trimmed text.
+func cellString(cell interface{}) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return strings.TrimSpace(fmt.Sprint(v))
+	}
+}
+
+// cellInt reads a whole number from a cell. An empty cell is zero.
+func cellInt(cell interface{}) (int, error) {
+	s := cellString(cell)
+	if s == "" {
+		return 0, nil
+	}
+
+	return strconv.Atoi(s)
+}
+
+// cellDate reads a date from a cell, which is either a serial number or
+// text. An empty cell is an error, since every transaction has a date.
+func cellDate(cell interface{}) (time.Time, error) {
+	if serial, ok := cell.(float64); ok {
+		t := serialEpoch.AddDate(0, 0, int(serial))
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local), nil
+	}
+
+	s := cellString(cell)
+	if s == "" {
+		return time.Time{}, fmt.Errorf("missing")
+	}
+
+	return dateparse.ParseLocal(s)
+}
+
+// cellPennies reads an amount of money from a cell, in pennies. Text may
+// have a currency sign, thousands separators, or parentheses meaning a
+// negative amount. An empty cell is zero.
+func cellPennies(cell interface{}) (int64, error) {
+	if v, ok := cell.(float64); ok {
+		return int64(math.Round(v * 100)), nil
+	}
+
+	s := cellString(cell)
+	if s == "" {
+		return 0, nil
+	}
+
+	negative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		negative = true
+		s = s[1 : len(s)-1]
+	}
+	s = strings.NewReplacer("$", "", ",", "", " ", "").Replace(s)
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", cellString(cell))
+	}
+	if negative {
+		v = -v
+	}
+
+	return int64(math.Round(v * 100)), nil
+}
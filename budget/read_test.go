@@ -0,0 +1,76 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package budget
+
+import (
+	"testing"
+	"time"
+)
+
+// Rows appended by AppendArray read back as the same transaction
+func TestFromSpreadsheetRow(t *testing.T) {
+	day := time.Date(2018, time.January, 2, 0, 0, 0, 0, time.Local)
+	want := Transaction{
+		Index:          3,
+		Date:           day,
+		Type:           "POS",
+		Description:    "Coffee",
+		DebitPennies:   523,
+		BalancePennies: 123456,
+		Category:       "Food",
+	}
+
+	t.Run("Unformatted", func(t *testing.T) {
+		serial := day.Sub(time.Date(1899, time.December, 30, 0, 0, 0, 0, time.Local)).Hours() / 24
+		row := []interface{}{"Food", 3.0, serial, "POS", "Coffee", 5.23, 0.0, 1234.56}
+		if got, err := FromSpreadsheetRow(row); err != nil || got != want {
+			t.Errorf("Expected %+v, got %+v, %v", want, got, err)
+		}
+	})
+	t.Run("Text", func(t *testing.T) {
+		row := []interface{}{"Food", "3", "1/2/2018", "POS", "Coffee", "$5.23", "", "$1,234.56"}
+		if got, err := FromSpreadsheetRow(row); err != nil || got != want {
+			t.Errorf("Expected %+v, got %+v, %v", want, got, err)
+		}
+	})
+	t.Run("Negative", func(t *testing.T) {
+		row := []interface{}{"", "", "1/2/2018", "", "", "", "", "(12.50)"}
+		if got, err := FromSpreadsheetRow(row); err != nil || got.BalancePennies != -1250 {
+			t.Errorf("Expected a balance of -1250, got %+v, %v", got, err)
+		}
+	})
+	t.Run("BadAmount", func(t *testing.T) {
+		row := []interface{}{"Food", 3.0, "1/2/2018", "POS", "Coffee", "lots"}
+		if _, err := FromSpreadsheetRow(row); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+	t.Run("NoDate", func(t *testing.T) {
+		if _, err := FromSpreadsheetRow([]interface{}{"Food", 3.0}); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
+
+// Blank and bad rows are skipped, and each transaction knows its row
+func TestFromRows(t *testing.T) {
+	rows := [][]interface{}{
+		{"Food", "1", "1/2/2018", "POS", "Coffee", "5.23"},
+		{},
+		{"Food", "2", "someday", "POS", "Coffee", "5.23"},
+		{"", "3", "1/3/2018", "POS", "Rent", "1000"},
+	}
+
+	transactions, indexes, rowErrors := fromRows("Checking", rows)
+	if len(transactions) != 2 || transactions[1].Description != "Rent" {
+		t.Errorf("Expected 2 transactions, got %+v", transactions)
+	}
+	if len(indexes) != 2 || indexes[0] != 0 || indexes[1] != 3 {
+		t.Errorf("Expected indexes 0 and 3, got %v", indexes)
+	}
+	if len(rowErrors) != 1 || rowErrors[0].Row != 4 {
+		t.Errorf("Expected an error on row 4, got %v", rowErrors)
+	}
+}
@@ -10,76 +10,567 @@
 package budget
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/budney/budget/index"
+	"github.com/budney/budget/retry"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/sheets/v4"
 	"log"
+	"regexp"
 	"sort"
-	"sync"
+	"strconv"
+	"time"
 )
 
 // HeaderRange gives the location of the transaction header
 const HeaderRange = "A1:H1"
+
 // DataRange gives the location of the transactions
 const DataRange = "A2:H"
 
+// DefaultChunkSize is the most rows appended in one request, by default
+const DefaultChunkSize = 500
+
+// DefaultWritesPerMinute is the default limit on write requests, which
+// matches the Sheets API's default per-user quota
+const DefaultWritesPerMinute = 60
+
 // Spreadsheet has the same structure as a Record, and holds
 // high-level information about a spreadsheet.
 type Spreadsheet struct {
-	index.Record   // Location, date range covered, etc.
-	sheets.Service // Adds the Google Sheets API to this struct
+	index.Record                 // Location, date range covered, etc.
+	sheets.Service               // Adds the Google Sheets API to this struct
+	Retry          retry.Policy  // How to retry API calls that fail
+	ChunkSize      int           // The most rows to append in one request
+	Limiter        *rate.Limiter // Limits write requests, shared by all spreadsheets
+	Journal        Journal       // Records appends before they're sent, if not nil
+}
+
+// A Journal records each chunk of rows before it's appended, and again
+// once the append is done, so that a run that dies in between can be
+// finished by the next one. See Replay.
+type Journal interface {
+	// Plan records that the rows, converted from the transactions, are
+	// about to be appended. It returns an ID for the batch.
+	Plan(spreadsheetID string, worksheet string, transactions []Transaction, rows [][]interface{}) (string, error)
+
+	// Commit records that a batch was appended to the specified range.
+	Commit(batch string, updatedRange string) error
+
+	// Abort records that a batch certainly wasn't appended, because the
+	// request was refused or never sent, so it's not to be replayed.
+	Abort(batch string, reason error) error
+}
+
+// A NotAppendedError reports an append whose rows certainly weren't
+// written: the request was never sent, or Google refused it. Other
+// errors leave it unknown whether the rows were written.
+type NotAppendedError struct {
+	Err error
+}
+
+// Error returns the underlying error's message.
+func (e NotAppendedError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e NotAppendedError) Unwrap() error {
+	return e.Err
+}
+
+// refused reports whether an error is Google refusing a request, in
+// which case the request had no effect.
+func refused(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code >= 400 && apiErr.Code < 500
+}
+
+// An AppendResult reports the outcome of appending transactions to
+// one worksheet.
+type AppendResult struct {
+	Spreadsheet string // The Filename of the spreadsheet
+	Worksheet   string // The worksheet appended to
+	Received    int    // How many transactions were received
+	Appended    int    // How many of them were appended
+	Err         error  // Why the rest weren't, if they weren't
 }
 
 // AppendFromChannel runs a goroutine that listens to a channel for
 // transactions, filters out the ones that don't apply, and appends
 // the rest to the budget spreadsheet for the specified account. It
 // does the append when the channel is closed by the writer.
-func (spreadsheet *Spreadsheet) AppendFromChannel(input <-chan Transaction, wait *sync.WaitGroup, worksheet string, category string) {
+//
+// It returns a channel which receives exactly one AppendResult when the
+// goroutine is done, and is then closed.
+//
+// If ctx is cancelled before the input channel is closed, the buffered
+// transactions are discarded and nothing is appended. If it's cancelled
+// while appending, the chunk in flight is finished and the rest are
+// discarded. Either way, the result says what was written.
+func (spreadsheet *Spreadsheet) AppendFromChannel(ctx context.Context, input <-chan Transaction, worksheet string, category string) <-chan AppendResult {
+	results := make(chan AppendResult, 1)
+
 	go func() {
+		defer close(results)
+		result := AppendResult{Spreadsheet: spreadsheet.Filename, Worksheet: worksheet}
 		transactions := make([]Transaction, 0, 2)
 
-		for transaction := range input {
-			transactions = append(transactions, transaction)
+		for {
+			select {
+			case transaction, ok := <-input:
+				if !ok {
+					result.Received = len(transactions)
+					result.Appended, result.Err = spreadsheet.AppendArray(ctx, transactions, worksheet, category)
+					results <- result
+					return
+				}
+				transactions = append(transactions, transaction)
+			case <-ctx.Done():
+				log.Printf("%s: %s: discarded %d buffered transactions: %s", spreadsheet.Filename, worksheet, len(transactions), ctx.Err())
+				result.Received = len(transactions)
+				result.Err = ctx.Err()
+				results <- result
+				return
+			}
 		}
-
-		spreadsheet.AppendArray(transactions, worksheet, category)
-		wait.Done()
 	}()
+
+	return results
 }
 
 // AppendArray accepts an array of transaction records and appends them
 // to the spreadsheet, sorted by Date and Index. It uses the worksheet
 // whose name exactly matches the account, and it puts the provided
-// category in the first spreadsheet column.
+// category in the first spreadsheet column of transactions that don't
+// have a category of their own. It returns the number of transactions
+// appended.
+//
+// Large arrays are appended in chunks of ChunkSize rows, one request
+// per chunk, in order. Each request waits its turn from Limiter. If a
+// chunk fails, the chunks before it have already been appended.
+//
+// Cancelling ctx stops the append between chunks, or while waiting for
+// quota or to retry. A request that has already been sent is allowed to
+// finish, so that it's never unclear whether its rows were written.
+//
+// With a Journal, each chunk is planned before it's sent and committed
+// after, so a chunk that was sent but never committed can be replayed. A
+// chunk that was refused, or never sent, is aborted instead.
 //
 // NOTE! This method appends everything it's given. It doesn't filter
 // the records based on date, or anything else. If you call this
 // method directly, you should know what you're doing.
-func (spreadsheet *Spreadsheet) AppendArray(transactions []Transaction, worksheet string, category string) error {
+func (spreadsheet *Spreadsheet) AppendArray(ctx context.Context, transactions []Transaction, worksheet string, category string) (int, error) {
 	// Sort the transactions in place by Date and Index
 	sort.Sort(byDate(transactions))
 
 	// Extract the transaction records in column order
-	rows := make([][]interface{}, 2)
+	rows := make([][]interface{}, 0, len(transactions))
 	for _, transaction := range transactions {
-		rows = append(rows, []interface{}{
-			category,
-			transaction.Index,
-			transaction.Date.Format("1/2/2006"),
-			transaction.Type,
-			transaction.Description,
-			transaction.DebitPennies / 100.0,
-			transaction.CreditPennies / 100.0,
-			transaction.BalancePennies / 100.0,
-		})
+		rows = append(rows, transactionRow(transaction, category))
+	}
+
+	chunkSize := spreadsheet.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	for start := 0; start < len(rows); start += chunkSize {
+		end := start + chunkSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		updatedRange, err := spreadsheet.appendChunk(ctx, worksheet, transactions[start:end], rows[start:end])
+		if err != nil {
+			// If only the journal failed, the chunk was appended
+			if updatedRange != "" {
+				start = end
+			}
+			log.Printf("%s: %s: appended %d of %d transactions: %s", spreadsheet.Filename, worksheet, start, len(rows), err)
+			return start, err
+		}
+		log.Printf("%s: appended %d transactions to %s", spreadsheet.Filename, end-start, updatedRange)
+	}
+
+	log.Printf("%s: %s: appended %d transactions", spreadsheet.Filename, worksheet, len(rows))
+	return len(rows), nil
+}
+
+// appendChunk appends one chunk of rows, journaling it if there's a
+// journal, and returns the range the rows were written to. If the rows
+// were appended but the journal couldn't record it, both the range and
+// the error are returned.
+func (spreadsheet *Spreadsheet) appendChunk(ctx context.Context, worksheet string, transactions []Transaction, rows [][]interface{}) (string, error) {
+	var batch string
+	var err error
+	if spreadsheet.Journal != nil {
+		batch, err = spreadsheet.Journal.Plan(spreadsheet.SpreadsheetID, worksheet, transactions, rows)
+		if err != nil {
+			return "", err
+		}
 	}
 
+	updatedRange, err := spreadsheet.appendRows(ctx, worksheet, rows)
+	if err != nil {
+		// Rows that weren't appended are never to be replayed
+		var notAppended NotAppendedError
+		if spreadsheet.Journal != nil && errors.As(err, &notAppended) {
+			if abortErr := spreadsheet.Journal.Abort(batch, err); abortErr != nil {
+				log.Printf("%s: %s: couldn't abort journal batch %s: %s", spreadsheet.Filename, worksheet, batch, abortErr)
+			}
+		}
+		return "", err
+	}
+
+	if spreadsheet.Journal != nil {
+		err = spreadsheet.Journal.Commit(batch, updatedRange)
+	}
+
+	return updatedRange, err
+}
+
+// appendRows appends rows to the worksheet in a single request, and
+// returns the range they were written to. If the rows certainly weren't
+// written, the error is a NotAppendedError.
+//
+// Requests that Google refused are retried as the retry policy allows.
+// After any other failure, the worksheet is read back before trying
+// again, and if the rows turn out to be there, they're not sent twice.
+func (spreadsheet *Spreadsheet) appendRows(ctx context.Context, worksheet string, rows [][]interface{}) (string, error) {
 	area := worksheet + "!" + DataRange
 	valueRange := &sheets.ValueRange{Range: area, MajorDimension: "ROWS", Values: rows}
-	_, err := spreadsheet.Spreadsheets.Values.Append(spreadsheet.SpreadsheetId, area, valueRange).ValueInputOption("USER_ENTERED").Do()
+
+	// Appending isn't idempotent: an attempt that failed after it was
+	// sent may have been applied anyway, so it's only tried again once
+	// the rows are known not to be there
+	var response *sheets.AppendValuesResponse
+	var foundRange string
+	sent := false
+	err := spreadsheet.Retry.Do(ctx, "Append to "+area, func() error {
+		if sent {
+			existing, err := spreadsheet.readRows(ctx, worksheet)
+			if err != nil {
+				return err
+			}
+			if updatedRange, ok := appendedRange(worksheet, existing, rows); ok {
+				log.Printf("%s: %s: the failed append went through after all", spreadsheet.Filename, worksheet)
+				foundRange = updatedRange
+				return nil
+			}
+		}
+
+		if err := spreadsheet.waitForQuota(ctx); err != nil {
+			return err
+		}
+
+		var err error
+		response, err = spreadsheet.Spreadsheets.Values.Append(spreadsheet.SpreadsheetID, area, valueRange).ValueInputOption("USER_ENTERED").Do()
+		if err != nil && !refused(err) {
+			sent = true
+		}
+		return err
+	})
 	if err != nil {
-		log.Printf("Couldn't append transactions: %s", err)
+		if !sent {
+			err = NotAppendedError{Err: err}
+		}
+		return "", err
+	}
+
+	if foundRange != "" {
+		return foundRange, nil
+	}
+	if response == nil || response.Updates == nil {
+		return area, nil
+	}
+	return response.Updates.UpdatedRange, nil
+}
+
+// Replay appends rows that may or may not have been appended already,
+// because the run that sent them died before hearing back. If the
+// worksheet already holds the rows, one after another, they aren't
+// appended again. Either way, it returns the range holding them.
+//
+// The category column isn't compared, since it's often changed by hand
+// after the rows are appended.
+func (spreadsheet *Spreadsheet) Replay(ctx context.Context, worksheet string, rows [][]interface{}) (string, error) {
+	existing, err := spreadsheet.readRows(ctx, worksheet)
+	if err != nil {
+		return "", err
+	}
+
+	if updatedRange, ok := appendedRange(worksheet, existing, rows); ok {
+		log.Printf("%s: %s: %d transactions were already appended", spreadsheet.Filename, worksheet, len(rows))
+		return updatedRange, nil
+	}
+
+	return spreadsheet.appendRows(ctx, worksheet, rows)
+}
+
+// readRows reads the rows of a worksheet's DataRange, unformatted, for
+// comparing with rows that may have been appended.
+func (spreadsheet *Spreadsheet) readRows(ctx context.Context, worksheet string) ([][]interface{}, error) {
+	area := worksheet + "!" + DataRange
+
+	var existing *sheets.ValueRange
+	err := spreadsheet.Retry.Do(ctx, "Read "+area, func() error {
+		var err error
+		existing, err = spreadsheet.Spreadsheets.Values.Get(spreadsheet.SpreadsheetID, area).
+			ValueRenderOption("UNFORMATTED_VALUE").DateTimeRenderOption("SERIAL_NUMBER").Do()
 		return err
+	})
+	if err != nil || existing == nil {
+		return nil, err
 	}
 
+	return existing.Values, nil
+}
+
+// appendedRange returns the range of the worksheet where rows were
+// already appended, if they were, given the rows of its DataRange.
+func appendedRange(worksheet string, existing [][]interface{}, rows [][]interface{}) (string, bool) {
+	at := findRows(existing, rows)
+	if at < 0 {
+		return "", false
+	}
+
+	// DataRange starts on the second row
+	first, last := at+2, at+len(rows)+1
+	return fmt.Sprintf("%s!A%d:H%d", worksheet, first, last), true
+}
+
+// DeleteRows deletes rows that were appended to the worksheet, at the
+// range the append reported, after checking that they're still there
+// and unchanged. Rows below them move up.
+//
+// As with Replay, the category column isn't compared.
+func (spreadsheet *Spreadsheet) DeleteRows(ctx context.Context, worksheet string, updatedRange string, rows [][]interface{}) error {
+	first, last, err := rowNumbers(updatedRange)
+	if err != nil {
+		return err
+	}
+	if last-first+1 != len(rows) {
+		return fmt.Errorf("%s holds %d rows, not %d", updatedRange, last-first+1, len(rows))
+	}
+
+	var existing *sheets.ValueRange
+	err = spreadsheet.Retry.Do(ctx, "Read "+updatedRange, func() error {
+		var err error
+		existing, err = spreadsheet.Spreadsheets.Values.Get(spreadsheet.SpreadsheetID, updatedRange).
+			ValueRenderOption("UNFORMATTED_VALUE").DateTimeRenderOption("SERIAL_NUMBER").Do()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if existing == nil || len(existing.Values) != len(rows) || findRows(existing.Values, rows) != 0 {
+		return fmt.Errorf("%s: %s has changed since it was appended", spreadsheet.Filename, updatedRange)
+	}
+
+	sheetID, err := spreadsheet.sheetID(ctx, worksheet)
+	if err != nil {
+		return err
+	}
+
+	// Dimension ranges count from zero, and exclude the end
+	request := &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{{
+			DeleteDimension: &sheets.DeleteDimensionRequest{
+				Range: &sheets.DimensionRange{
+					SheetId:         sheetID,
+					Dimension:       "ROWS",
+					StartIndex:      int64(first - 1),
+					EndIndex:        int64(last),
+					ForceSendFields: []string{"SheetId", "StartIndex"},
+				},
+			},
+		}},
+	}
+
+	err = spreadsheet.Retry.Do(ctx, "Delete "+updatedRange, func() error {
+		if err := spreadsheet.waitForQuota(ctx); err != nil {
+			return err
+		}
+
+		_, err := spreadsheet.Spreadsheets.BatchUpdate(spreadsheet.SpreadsheetID, request).Do()
+		return err
+	})
+	if err != nil {
+		log.Printf("Couldn't delete %s: %s", updatedRange, err)
+		return err
+	}
+
+	log.Printf("%s: deleted %d transactions from %s", spreadsheet.Filename, len(rows), updatedRange)
 	return nil
 }
+
+// sheetID looks up the numeric ID of a worksheet, which some requests
+// use instead of its name.
+func (spreadsheet *Spreadsheet) sheetID(ctx context.Context, worksheet string) (int64, error) {
+	var response *sheets.Spreadsheet
+	err := spreadsheet.Retry.Do(ctx, "Read worksheets", func() error {
+		var err error
+		response, err = spreadsheet.Spreadsheets.Get(spreadsheet.SpreadsheetID).Fields("sheets.properties").Do()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if response != nil {
+		for _, sheet := range response.Sheets {
+			if sheet.Properties != nil && sheet.Properties.Title == worksheet {
+				return sheet.Properties.SheetId, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("%s: no worksheet named %q", spreadsheet.Filename, worksheet)
+}
+
+// rangeRows matches the rows of an A1-notation range, like "Sheet!A5:H7".
+var rangeRows = regexp.MustCompile(`![A-Z]+(\d+):[A-Z]+(\d+)$`)
+
+// rowNumbers returns the first and last row numbers of a range.
+func rowNumbers(a1 string) (int, int, error) {
+	m := rangeRows.FindStringSubmatch(a1)
+	if m == nil {
+		return 0, 0, fmt.Errorf("can't find the rows of range %q", a1)
+	}
+
+	first, _ := strconv.Atoi(m[1])
+	last, _ := strconv.Atoi(m[2])
+	return first, last, nil
+}
+
+// findRows returns the index of the last place in existing where rows
+// appear one after another, or -1 if they don't. Existing rows were read
+// back unformatted, so dates are serial numbers.
+func findRows(existing [][]interface{}, rows [][]interface{}) int {
+	if len(rows) == 0 {
+		return -1
+	}
+
+	want := make([][]string, len(rows))
+	for i, row := range rows {
+		want[i] = rowKey(row, false)
+	}
+
+search:
+	for at := len(existing) - len(rows); at >= 0; at-- {
+		for i := range rows {
+			got := rowKey(existing[at+i], true)
+			for j := range want[i] {
+				if j >= len(got) || got[j] != want[i][j] {
+					continue search
+				}
+			}
+		}
+		return at
+	}
+
+	return -1
+}
+
+// rowKey converts the cells of a row, other than the category, to
+// strings for comparison. If serial is true, the date is a spreadsheet
+// serial number; otherwise it's formatted as transactionRow formats it.
+func rowKey(row []interface{}, serial bool) []string {
+	key := make([]string, 0, len(row))
+	for i := 1; i < len(row); i++ {
+		cell := row[i]
+
+		if i == 2 && !serial {
+			if s, ok := cell.(string); ok {
+				if date, err := time.Parse("1/2/2006", s); err == nil {
+					cell = date.Sub(serialEpoch).Hours() / 24
+				}
+			}
+		}
+
+		switch v := cell.(type) {
+		case float64:
+			key = append(key, strconv.FormatFloat(v, 'f', -1, 64))
+		case int:
+			key = append(key, strconv.Itoa(v))
+		default:
+			key = append(key, fmt.Sprint(v))
+		}
+	}
+
+	return key
+}
+
+// serialEpoch is day zero of spreadsheet serial dates.
+var serialEpoch = time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)
+
+// Clear deletes every transaction from the worksheet, leaving the header.
+func (spreadsheet *Spreadsheet) Clear(ctx context.Context, worksheet string) error {
+	area := worksheet + "!" + DataRange
+
+	err := spreadsheet.Retry.Do(ctx, "Clear "+area, func() error {
+		if err := spreadsheet.waitForQuota(ctx); err != nil {
+			return err
+		}
+
+		_, err := spreadsheet.Spreadsheets.Values.Clear(spreadsheet.SpreadsheetID, area, &sheets.ClearValuesRequest{}).Do()
+		return err
+	})
+	if err != nil {
+		log.Printf("Couldn't clear %s: %s", area, err)
+	}
+
+	return err
+}
+
+// transactionRow converts a transaction into a spreadsheet row, in
+// column order. The transaction's own category, if it has one, takes
+// precedence over the one provided.
+func transactionRow(transaction Transaction, category string) []interface{} {
+	if transaction.Category != "" {
+		category = transaction.Category
+	}
+
+	return []interface{}{
+		category,
+		transaction.Index,
+		transaction.Date.Format("1/2/2006"),
+		transaction.Type,
+		transaction.Description,
+		float64(transaction.DebitPennies) / 100.0,
+		float64(transaction.CreditPennies) / 100.0,
+		float64(transaction.BalancePennies) / 100.0,
+	}
+}
+
+// waitForQuota blocks until the spreadsheet's limiter allows another
+// write request, or ctx is done. Without a limiter, it returns
+// immediately.
+func (spreadsheet *Spreadsheet) waitForQuota(ctx context.Context) error {
+	if spreadsheet.Limiter == nil {
+		return nil
+	}
+
+	return spreadsheet.Limiter.Wait(ctx)
+}
+
+// NewWriteLimiter returns a token-bucket limiter allowing the specified
+// number of write requests per minute, with bursts of up to burst
+// requests. Every Spreadsheet written to in a run should share the same
+// limiter, since the Sheets write quota is per user, not per spreadsheet.
+// Zero values mean DefaultWritesPerMinute and a burst of one request.
+func NewWriteLimiter(writesPerMinute float64, burst int) *rate.Limiter {
+	if writesPerMinute <= 0 {
+		writesPerMinute = DefaultWritesPerMinute
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+
+	return rate.NewLimiter(rate.Limit(writesPerMinute/60), burst)
+}
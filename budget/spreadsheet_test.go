@@ -0,0 +1,92 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package budget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Replayed rows are found even if their category was changed
+func TestFindRows(t *testing.T) {
+	sent := [][]interface{}{
+		{"Uncategorized", 1, "1/2/2018", "POS", "Coffee", 5.23, 0.0, 100.0},
+		{"Uncategorized", 2, "1/2/2018", "POS", "Bagel", 2.5, 0.0, 97.5},
+	}
+	serial := 43102.0 // 1/2/2018
+	existing := [][]interface{}{
+		{"Rent", 1.0, serial - 1, "ACH", "Landlord", 1000.0, 0.0, 105.23},
+		{"Food", 1.0, serial, "POS", "Coffee", 5.23, 0.0, 100.0},
+		{"Uncategorized", 2.0, serial, "POS", "Bagel", 2.5, 0.0, 97.5},
+	}
+
+	if at := findRows(existing, sent); at != 1 {
+		t.Errorf("Expected rows at 1, got %d", at)
+	}
+	if at := findRows(existing[:2], sent); at != -1 {
+		t.Errorf("Expected no rows, got %d", at)
+	}
+}
+
+// Replay finds rows already appended, and where they are
+func TestAppendedRange(t *testing.T) {
+	sent := [][]interface{}{{"Uncategorized", 1, "1/2/2018", "POS", "Coffee", 5.23, 0.0, 100.0}}
+	existing := [][]interface{}{
+		{"Rent", 1.0, 43101.0, "ACH", "Landlord", 1000.0, 0.0, 105.23},
+		{"Food", 1.0, 43102.0, "POS", "Coffee", 5.23, 0.0, 100.0},
+	}
+
+	if got, ok := appendedRange("Checking", existing, sent); !ok || got != "Checking!A3:H3" {
+		t.Errorf("Expected Checking!A3:H3, got %q, %v", got, ok)
+	}
+	if got, ok := appendedRange("Checking", existing[:1], sent); ok {
+		t.Errorf("Expected no range, got %q", got)
+	}
+}
+
+// Only refused requests and requests never sent certainly had no effect
+func TestRefused(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&googleapi.Error{Code: 400}, true},
+		{fmt.Errorf("append: %w", &googleapi.Error{Code: 403}), true},
+		{&googleapi.Error{Code: 429}, true},
+		{&googleapi.Error{Code: 503}, false},
+		{context.DeadlineExceeded, false},
+		{errors.New("connection reset"), false},
+	}
+
+	for _, test := range tests {
+		if got := refused(test.err); got != test.want {
+			t.Errorf("%v: expected %v, got %v", test.err, test.want, got)
+		}
+	}
+}
+
+// Only a range naming a missing worksheet means there isn't one
+func TestNoWorksheet(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&googleapi.Error{Code: 400, Message: "Unable to parse range: Budget!A2:B"}, true},
+		{fmt.Errorf("read: %w", &googleapi.Error{Code: 400, Message: "Unable to parse range: Budget!A2:B"}), true},
+		{&googleapi.Error{Code: 400, Message: "Invalid requests[0]: spreadsheet ID"}, false},
+		{&googleapi.Error{Code: 404, Message: "Requested entity was not found."}, false},
+		{errors.New("connection reset"), false},
+	}
+
+	for _, test := range tests {
+		if got := noWorksheet(test.err); got != test.want {
+			t.Errorf("%v: expected %v, got %v", test.err, test.want, got)
+		}
+	}
+}
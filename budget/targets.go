@@ -0,0 +1,122 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package budget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+// TargetsWorksheet is the optional worksheet that lists how much is
+// budgeted for each category per month
+const TargetsWorksheet = "Budget"
+
+// TargetsRange gives the location of the targets: a category in the
+// first column, and a monthly amount in the second
+const TargetsRange = "A2:B"
+
+// SummaryWorksheet is the worksheet that budget-vs-actual results are
+// written to, if asked
+const SummaryWorksheet = "Summary"
+
+// ReadTargets reads the monthly target for each category, in pennies,
+// from the spreadsheet's Budget worksheet. A spreadsheet without one
+// has no targets, which isn't an error. Rows that can't be read are
+// skipped, and reported together in a RowErrors.
+func (spreadsheet *Spreadsheet) ReadTargets(ctx context.Context) (map[string]int64, error) {
+	area := TargetsWorksheet + "!" + TargetsRange
+
+	var response *sheets.ValueRange
+	err := spreadsheet.Retry.Do(ctx, "Read "+area, func() error {
+		var err error
+		response, err = spreadsheet.Spreadsheets.Values.Get(spreadsheet.SpreadsheetID, area).
+			ValueRenderOption("UNFORMATTED_VALUE").Do()
+		return err
+	})
+
+	if noWorksheet(err) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Unable to read %s from %s: %v", area, spreadsheet.Filename, err)
+		return nil, err
+	}
+	if response == nil {
+		return nil, nil
+	}
+
+	targets := make(map[string]int64)
+	var rowErrors RowErrors
+	for i, row := range response.Values {
+		if blankRow(row) {
+			continue
+		}
+
+		category := cellString(row[0])
+		var amount interface{} = ""
+		if len(row) > 1 {
+			amount = row[1]
+		}
+
+		pennies, err := cellPennies(amount)
+		if err == nil && category == "" {
+			err = fmt.Errorf("no category")
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Worksheet: TargetsWorksheet, Row: i + 2, Err: err})
+			continue
+		}
+		targets[category] = pennies
+	}
+
+	if len(rowErrors) > 0 {
+		return targets, rowErrors
+	}
+	return targets, nil
+}
+
+// noWorksheet reports whether an error is Google saying that a range
+// names a worksheet that doesn't exist. Other bad requests, like a
+// malformed spreadsheet ID, are real errors.
+func noWorksheet(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest &&
+		strings.Contains(apiErr.Message, "Unable to parse range")
+}
+
+// WriteWorksheet replaces the contents of a worksheet with rows, starting
+// at the top left. The worksheet must already exist.
+func (spreadsheet *Spreadsheet) WriteWorksheet(ctx context.Context, worksheet string, rows [][]interface{}) error {
+	area := worksheet + "!A1:Z"
+
+	err := spreadsheet.Retry.Do(ctx, "Write "+area, func() error {
+		if err := spreadsheet.waitForQuota(ctx); err != nil {
+			return err
+		}
+		_, err := spreadsheet.Spreadsheets.Values.Clear(spreadsheet.SpreadsheetID, area, &sheets.ClearValuesRequest{}).Do()
+		if err != nil {
+			return err
+		}
+
+		if err := spreadsheet.waitForQuota(ctx); err != nil {
+			return err
+		}
+		valueRange := &sheets.ValueRange{Range: area, MajorDimension: "ROWS", Values: rows}
+		_, err = spreadsheet.Spreadsheets.Values.Update(spreadsheet.SpreadsheetID, area, valueRange).ValueInputOption("USER_ENTERED").Do()
+		return err
+	})
+	if err != nil {
+		log.Printf("Couldn't write %s: %s", area, err)
+	}
+
+	return err
+}
@@ -5,7 +5,7 @@
 package budget
 
 import (
-    "time"
+	"time"
 )
 
 // A Transaction contains information about a single transaction.
@@ -14,7 +14,17 @@ type Transaction struct {
 	Date           time.Time // The date of the transaction
 	Type           string    // A type description, such as POS, Check, ATM, etc.
 	Description    string    // Usually the payor / payee of the transaction
+	RawDescription string    // The description as the source gave it, if it's been tidied up
 	DebitPennies   int64     // The debit amount, in pennies
 	CreditPennies  int64     // The credit amount, in pennies
 	BalancePennies int64     // The balance, in pennies, after the transaction
+	Account        string    // The name of the account, if known
+	Category       string    // The budget category, if known
+	ValueDate      time.Time // When the money actually moved, if the source says
+	Reference      string    // The source's own ID for the transaction, if it has one
+	Occurrence     int       // Which of several identical transactions on the same day this is, from 0
+	Currency       string    // The ISO 4217 currency code, if the source says
+	Payee          string    // The payee, cleaned up from the Description, if known
+	Location       string    // Where the transaction happened, like "ANYTOWN, NY", if known
+	CardSuffix     string    // The last digits of the card used, if known
 }
@@ -0,0 +1,90 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/budney/budget/app"
+	"github.com/budney/budget/budget"
+	"github.com/budney/budget/index"
+	"github.com/budney/budget/pipeline"
+)
+
+// runCategorize assigns categories to the uncategorized transactions in
+// the budget between two dates, using the rules of the pipeline's
+// categorize stage, and writes them back to the worksheets. With --all,
+// transactions that already have a category get the rules' instead.
+func runCategorize(ctx context.Context, args []string) {
+	var dates app.DateRange
+	fs := newFlagSet("categorize", "")
+	dates.AddFlags(fs, "this-year", "today")
+	all := fs.Bool("all", false, "Recategorize transactions that already have a category")
+	flags := parseFlags(fs, args)
+
+	start, end, err := dates.Resolve(time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var rules []pipeline.Rule
+	for _, config := range flags.Pipeline {
+		if config.Stage == "categorize" {
+			rules = append(rules, config.Rules...)
+		}
+	}
+	if len(rules) == 0 {
+		log.Fatal("The pipeline has no categorize stage, so there are no rules to categorize by")
+	}
+	categorizer, err := pipeline.NewCategorizer(rules)
+	if err != nil {
+		log.Fatalf("Couldn't configure pipeline: %s", err)
+	}
+
+	categorize := func(transaction *budget.Transaction) bool {
+		if transaction.Date.Before(start) || transaction.Date.After(end) {
+			return false
+		}
+		if !*all && transaction.Category != "" && transaction.Category != "Uncategorized" {
+			return false
+		}
+
+		category := transaction.Category
+		return categorizer.Categorize(transaction) && transaction.Category != category
+	}
+
+	ok := true
+	limiter := budget.NewWriteLimiter(flags.Sheets.WritesPerMinute, flags.Sheets.WriteBurst)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SPREADSHEET\tWORKSHEET\tCATEGORIZED\tSTATUS")
+	for _, record := range index.FilterOverlapping(getBudgetIndex(ctx, flags), start, end) {
+		spreadsheet := newSpreadsheet(flags, record, limiter)
+		for _, account := range flags.Bank.Accounts {
+			worksheet := flags.Worksheet(account)
+			changed, err := spreadsheet.Recategorize(ctx, worksheet, categorize)
+
+			// Rows that couldn't be read were logged, and left alone
+			status := "OK"
+			var rowErrors budget.RowErrors
+			if err != nil && !errors.As(err, &rowErrors) {
+				status = err.Error()
+				ok = false
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", spreadsheet.Filename, worksheet, changed, status)
+		}
+	}
+	w.Flush()
+
+	if !ok {
+		os.Exit(1)
+	}
+}
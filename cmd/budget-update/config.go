@@ -0,0 +1,62 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/budney/budget/app"
+)
+
+// runConfig implements the config subcommands. Unlike the other
+// commands, it doesn't die if the configuration can't be loaded, but
+// reports the problem instead.
+func runConfig(ctx context.Context, args []string) {
+	fs := newFlagSet("config", "check")
+	flags, err := app.ParseFlagSet(fs, args)
+	if fs.NArg() != 1 || fs.Arg(0) != "check" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	configCheck(flags, err)
+}
+
+// configCheck prints every effective option value and where it came
+// from, followed by any problems with the configuration. It exits
+// non-zero if there are errors.
+func configCheck(flags app.Flags, err error) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "OPTION\tVALUE\tFROM")
+	for _, value := range flags.Values() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", value.Option, value.Value, value.Origin)
+	}
+	w.Flush()
+	fmt.Println()
+
+	// A config that couldn't be loaded can't be checked any further
+	if err != nil {
+		fmt.Printf("error: %s\n", err)
+		os.Exit(1)
+	}
+
+	errors := 0
+	for _, diagnostic := range flags.Check() {
+		fmt.Println(diagnostic)
+		if diagnostic.Severity == app.SeverityError {
+			errors++
+		}
+	}
+
+	if errors > 0 {
+		fmt.Printf("%d error(s) found\n", errors)
+		os.Exit(1)
+	}
+
+	fmt.Println("Configuration OK")
+}
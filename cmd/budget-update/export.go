@@ -0,0 +1,182 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/budney/budget/app"
+	"github.com/budney/budget/budget"
+	"github.com/budney/budget/export"
+	"github.com/budney/budget/ledger"
+	"github.com/budney/budget/pipeline"
+)
+
+// runExport writes the transactions in every budget spreadsheet between
+// two dates, as CSV, JSON Lines or JSON with the chosen columns, or as a
+// ledger or Beancount journal.
+func runExport(ctx context.Context, args []string) {
+	var dates app.DateRange
+	fs := newFlagSet("export", "")
+	dates.AddFlags(fs, "this-year", "today")
+	format := fs.String("format", "ledger", "The output `format`: ledger, beancount, csv, jsonl or json")
+	columnNames := fs.String("columns", "", "For csv, jsonl and json, a comma-separated `list` of columns: "+strings.Join(export.ColumnNames(), ","))
+	output := fs.String("output", "", "The `filename` to write (default: standard output)")
+	assertions := fs.Bool("balance-assertions", true, "For ledger and beancount, assert the bank balances, where they're known")
+	flags := parseFlags(fs, args)
+
+	start, end, err := dates.Resolve(time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Check the format before reading anything
+	var write func(io.Writer, []budget.Transaction) error
+	journal := false
+	switch *format {
+	case "csv", "jsonl", "json":
+		columns, err := export.ParseColumns(*columnNames)
+		if err != nil {
+			log.Fatal(err)
+		}
+		writers := map[string]func(io.Writer, []budget.Transaction, []export.Column) error{
+			"csv":   export.WriteCSV,
+			"jsonl": export.WriteJSONLines,
+			"json":  export.WriteJSON,
+		}
+		write = func(w io.Writer, transactions []budget.Transaction) error {
+			return writers[*format](w, transactions, columns)
+		}
+	case "ledger":
+		journal = true
+		options := export.DefaultLedgerOptions
+		options.Assertions = *assertions
+		write = func(w io.Writer, transactions []budget.Transaction) error {
+			return export.WriteLedger(w, transactions, options)
+		}
+	case "beancount":
+		journal = true
+		options := export.DefaultBeancountOptions
+		options.Balances = *assertions
+		write = func(w io.Writer, transactions []budget.Transaction) error {
+			return export.WriteBeancount(w, transactions, options)
+		}
+	default:
+		log.Fatalf("Unknown format %q", *format)
+	}
+
+	// Identical transactions on the same day get different IDs
+	transactions := between(readBudget(ctx, flags, start, end), start, end)
+	pipeline.NumberOccurrences(transactions)
+	if err = fromLedger(flags, transactions, start, end); err != nil {
+		log.Printf("Couldn't read value dates, currencies and references from the ledger: %s", err)
+	}
+	if err = normalizePayees(flags, transactions); err != nil {
+		log.Fatalf("Couldn't configure pipeline: %s", err)
+	}
+
+	// In journals, the bank worksheet is the asset account
+	if journal {
+		for i := range transactions {
+			transactions[i].Account = flags.Worksheet(transactions[i].Account)
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err = write(w, transactions); err != nil {
+		log.Fatalf("Couldn't write %s: %s", *format, err)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d transactions\n", len(transactions))
+}
+
+// fromLedger fills in the value dates, currencies and references of
+// transactions read back from the budget, which the worksheets don't
+// record, from the matching entries in the ledger, if there is one.
+func fromLedger(flags app.Flags, transactions []budget.Transaction, start time.Time, end time.Time) error {
+	if _, err := os.Stat(flags.Sheets.LedgerFile); os.IsNotExist(err) {
+		return nil
+	}
+
+	book, err := ledger.Open(flags.Sheets.LedgerFile)
+	if err != nil {
+		return err
+	}
+	defer book.Close()
+
+	entries, err := book.Between(start, end)
+	if err != nil {
+		return err
+	}
+
+	unmatched := make(map[string][]budget.Transaction)
+	for _, entry := range entries {
+		key := entry.Transaction.Account + "|" + rowContent(entry.Transaction)
+		unmatched[key] = append(unmatched[key], entry.Transaction)
+	}
+
+	for i, transaction := range transactions {
+		key := transaction.Account + "|" + rowContent(transaction)
+		if len(unmatched[key]) == 0 {
+			continue
+		}
+
+		entry := unmatched[key][0]
+		unmatched[key] = unmatched[key][1:]
+		transactions[i].ValueDate = entry.ValueDate
+		transactions[i].Currency = entry.Currency
+		transactions[i].Reference = entry.Reference
+	}
+
+	return nil
+}
+
+// normalizePayees fills in the payees of transactions read back from the
+// budget, if the pipeline has a normalize-payee stage, using its rules.
+func normalizePayees(flags app.Flags, transactions []budget.Transaction) error {
+	for _, config := range flags.Pipeline {
+		if config.Stage != "normalize-payee" {
+			continue
+		}
+
+		normalizer, err := pipeline.NewPayeeNormalizer(config.Payees)
+		if err != nil {
+			return err
+		}
+		for i := range transactions {
+			normalizer.Normalize(&transactions[i])
+		}
+		break
+	}
+
+	return nil
+}
+
+// between returns the transactions dated between start and end,
+// inclusive.
+func between(transactions []budget.Transaction, start time.Time, end time.Time) []budget.Transaction {
+	var result []budget.Transaction
+	for _, transaction := range transactions {
+		if !transaction.Date.Before(start) && !transaction.Date.After(end) {
+			result = append(result, transaction)
+		}
+	}
+
+	return result
+}
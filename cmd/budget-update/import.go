@@ -0,0 +1,105 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/budney/budget/budget"
+	"github.com/budney/budget/importer"
+)
+
+// runImport reads transactions from files that banks and financial
+// programs export, and appends them to the current budget spreadsheet
+// just as sync does with transactions downloaded from the bank.
+func runImport(ctx context.Context, args []string) {
+	fs := newFlagSet("import", "file...")
+	format := fs.String("format", "", "The `format` of the files: "+strings.Join(importer.Formats(), ", ")+" (default: from the file extension)")
+	flags := parseFlags(fs, args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// Files that don't name their accounts need exactly one --account
+	account := ""
+	if len(flags.Bank.Accounts) == 1 {
+		account = flags.Bank.Accounts[0]
+	}
+
+	var accounts []string
+	byAccount := make(map[string][]budget.Transaction)
+	var start, end time.Time
+	for _, fileName := range fs.Args() {
+		transactions := importFile(fileName, *format, account)
+
+		for _, transaction := range transactions {
+			if transaction.Account == "" {
+				log.Fatalf("%s doesn't name its account; choose one with --account", fileName)
+			}
+			if _, ok := byAccount[transaction.Account]; !ok {
+				accounts = append(accounts, transaction.Account)
+			}
+			byAccount[transaction.Account] = append(byAccount[transaction.Account], transaction)
+
+			if start.IsZero() || transaction.Date.Before(start) {
+				start = transaction.Date
+			}
+			if end.IsZero() || transaction.Date.After(end) {
+				end = transaction.Date
+			}
+		}
+	}
+	if len(accounts) == 0 {
+		log.Print("No transactions to import")
+		return
+	}
+
+	session := startSession(ctx, flags, "import", start, end)
+	defer session.close()
+
+	for _, account := range accounts {
+		transactions := byAccount[account]
+		session.appendAccount(ctx, account, start, end, func(context.Context) ([]budget.Transaction, error) {
+			return transactions, nil
+		})
+	}
+
+	if !session.finish(ctx) {
+		os.Exit(1)
+	}
+}
+
+// importFile reads the transactions in a file. If format is empty, it's
+// taken from the file's extension.
+func importFile(fileName string, format string, account string) []budget.Transaction {
+	if format == "" {
+		format = importer.FormatOf(fileName)
+	}
+
+	parse, ok := importer.Parsers[format]
+	if !ok {
+		log.Fatalf("%s: unknown format %q; choose one with --format", fileName, format)
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	transactions, err := parse(f, account)
+	if err != nil {
+		log.Fatalf("Couldn't import %s: %s", fileName, err)
+	}
+
+	log.Printf("%s: read %d transactions", fileName, len(transactions))
+	return transactions
+}
@@ -0,0 +1,32 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// runIndex prints the budget spreadsheets listed in the index.
+func runIndex(ctx context.Context, args []string) {
+	fs := newFlagSet("index", "")
+	flags := parseFlags(fs, args)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tFILENAME\tSTART\tEND\tLAST UPDATED\tSPREADSHEET ID")
+	for _, record := range getBudgetIndex(ctx, flags) {
+		lastUpdated := "never"
+		if !record.LastUpdated.IsZero() {
+			lastUpdated = record.LastUpdated.Format("2006-01-02 15:04")
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", record.Index, record.Filename,
+			record.Start.Format("2006-01-02"), record.End.Format("2006-01-02"),
+			lastUpdated, record.SpreadsheetID)
+	}
+	w.Flush()
+}
@@ -0,0 +1,262 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/budney/budget/app"
+	"github.com/budney/budget/budget"
+	"github.com/budney/budget/index"
+	"github.com/budney/budget/ledger"
+)
+
+// runLedger implements the ledger subcommands, which read the local
+// ledger of downloaded transactions, rebuild the budget from it, and
+// manage the journal of appends that never finished.
+func runLedger(ctx context.Context, args []string) {
+	var dates app.DateRange
+	fs := newFlagSet("ledger", "query | rebuild | pending | drop batch-id")
+	dates.AddFlags(fs, "30d", "today")
+	force := fs.Bool("force", false, "Let rebuild clear and rewrite worksheets")
+	flags := parseFlags(fs, args)
+	args = fs.Args()
+
+	want := 1
+	if len(args) > 0 && args[0] == "drop" {
+		want = 2
+	}
+	if len(args) != want {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	book, err := ledger.Open(flags.Sheets.LedgerFile)
+	if err != nil {
+		log.Fatalf("Couldn't open ledger: %s", err)
+	}
+	defer book.Close()
+
+	switch args[0] {
+	case "query":
+		start, end, err := dates.Resolve(time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		ledgerQuery(book, start, end, flags.Bank.Accounts)
+	case "rebuild":
+		start, end, err := dates.Resolve(time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !*force {
+			log.Fatal("Rebuilding clears the worksheets and rewrites them from the ledger; use --force to do it")
+		}
+		if !ledgerRebuild(ctx, flags, book, start, end) {
+			os.Exit(1)
+		}
+	case "pending":
+		ledgerPending(book)
+	case "drop":
+		if err := book.AbortBatch(args[1], "dropped by hand"); err != nil {
+			log.Fatalf("Couldn't drop batch %s: %s", args[1], err)
+		}
+		fmt.Printf("Dropped batch %s; its transactions will be sent again by the next sync\n", args[1])
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// ledgerQuery prints the ledger's transactions between start and end,
+// from the specified accounts, or from every account if none are.
+func ledgerQuery(book *ledger.Ledger, start time.Time, end time.Time, accounts []string) {
+	entries, err := book.Between(start, end, accounts...)
+	if err != nil {
+		log.Fatalf("Couldn't read ledger: %s", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tACCOUNT\tDESCRIPTION\tDEBIT\tCREDIT\tCATEGORY\tFIRST SEEN\tLAST SEEN")
+	for _, entry := range entries {
+		t := entry.Transaction
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.2f\t%.2f\t%s\t%s\t%s\n",
+			t.Date.Format("2006-01-02"), t.Account, t.Description,
+			float64(t.DebitPennies)/100, float64(t.CreditPennies)/100, t.Category,
+			entry.FirstSeen.Format("2006-01-02"), entry.LastSeen.Format("2006-01-02"))
+	}
+	w.Flush()
+}
+
+// ledgerPending prints the batches of rows that were planned but never
+// committed, which the next sync will replay. If one can't be replayed,
+// check whether its rows are in its worksheet, and drop it.
+func ledgerPending(book *ledger.Ledger) {
+	pending, err := book.Pending()
+	if err != nil {
+		log.Fatalf("Couldn't read journal: %s", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "BATCH\tRUN\tPLANNED\tSPREADSHEET\tWORKSHEET\tROWS")
+	for _, batch := range pending {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", batch.ID, batch.Run,
+			batch.Planned.Format("2006-01-02 15:04:05"), batch.SpreadsheetID, batch.Worksheet, len(batch.Rows))
+	}
+	w.Flush()
+}
+
+// ledgerRebuild clears each account's worksheet in every budget
+// spreadsheet whose period overlaps start and end, and rewrites it from
+// the ledger. It's recorded as a run, so it can be undone. It prints a
+// summary, and returns true if every worksheet was rebuilt.
+func ledgerRebuild(ctx context.Context, flags app.Flags, book *ledger.Ledger, start time.Time, end time.Time) bool {
+	records := getBudgetIndex(ctx, flags)
+	limiter := budget.NewWriteLimiter(flags.Sheets.WritesPerMinute, flags.Sheets.WriteBurst)
+
+	now := time.Now()
+	run := ledger.Run{ID: ledger.RunID(now), Started: now}
+	if err := book.SaveRun(run); err != nil {
+		log.Fatalf("Couldn't record run: %s", err)
+	}
+
+	var results []budget.AppendResult
+	for _, record := range index.FilterOverlapping(records, start, end) {
+		run.IndexID = record.IndexID
+		spreadsheet := newSpreadsheet(flags, record, limiter)
+		for _, account := range flags.Bank.Accounts {
+			worksheet := flags.Worksheet(account)
+			result := budget.AppendResult{Spreadsheet: spreadsheet.Filename, Worksheet: worksheet}
+			result.Received, result.Appended, result.Err = rebuildWorksheet(ctx, book, &run, records, &spreadsheet, worksheet, account)
+			results = append(results, result)
+		}
+	}
+	log.Printf("Run %s is done; undo it with \"budget-update undo %s\"", run.ID, run.ID)
+
+	return printSummary(results)
+}
+
+// rebuildWorksheet replaces the contents of one account's worksheet with
+// the account's transactions from the ledger that belong in the
+// spreadsheet, by the index. It returns the number of transactions found
+// and appended.
+//
+// Rows already in the worksheet keep their categories. If any of them
+// isn't in the ledger, it would be lost, so the worksheet is left alone.
+// Otherwise, its rows are saved in the run before it's cleared, and the
+// appends are journaled, so undoing the run puts it back.
+func rebuildWorksheet(ctx context.Context, book *ledger.Ledger, run *ledger.Run, records []index.Record, spreadsheet *budget.Spreadsheet, worksheet string, account string) (int, int, error) {
+	record := spreadsheet.Record
+	target := ledgerTarget(*spreadsheet, worksheet)
+
+	all, err := book.Between(record.Start, record.End, account)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var entries []ledger.Entry
+	var ids []string
+	var transactions []budget.Transaction
+	for _, entry := range all {
+		covering, ok := index.Covering(records, entry.Transaction.Date)
+		if !ok || covering.IndexID != record.IndexID || covering.Index != record.Index {
+			continue
+		}
+		entries = append(entries, entry)
+		ids = append(ids, entry.ID)
+		transactions = append(transactions, entry.Transaction)
+	}
+
+	existing, err := spreadsheet.ReadTransactions(ctx, worksheet)
+	if err != nil {
+		return len(transactions), 0, fmt.Errorf("not cleared, since its rows couldn't all be read: %v", err)
+	}
+	projected, err := keepCategories(existing, entries, transactions)
+	if err != nil {
+		return len(transactions), 0, err
+	}
+	if len(existing) == 0 && len(transactions) == 0 {
+		return 0, 0, nil
+	}
+
+	// Save what's there, so the rebuild can be undone
+	run.Cleared = append(run.Cleared, ledger.ClearedWorksheet{
+		SpreadsheetID: spreadsheet.SpreadsheetID,
+		Worksheet:     worksheet,
+		Transactions:  existing,
+		EntryIDs:      projected,
+	})
+	if err = book.SaveRun(*run); err != nil {
+		return len(transactions), 0, err
+	}
+
+	// Once the worksheet is cleared, nothing is projected to it
+	if err = spreadsheet.Clear(ctx, worksheet); err != nil {
+		return len(transactions), 0, err
+	}
+	if err = book.UnmarkProjected(ids, target); err != nil {
+		return len(transactions), 0, err
+	}
+
+	spreadsheet.Journal = book.EntriesJournal(run.ID, entries)
+	defer func() { spreadsheet.Journal = nil }()
+
+	appended, err := spreadsheet.AppendArray(ctx, transactions, worksheet, "Uncategorized")
+	if err != nil {
+		return len(transactions), appended, err
+	}
+
+	return len(transactions), appended, book.MarkProjected(ids, target)
+}
+
+// keepCategories matches each row read from a worksheet with one of the
+// entries that will replace it, and gives the entry's transaction the
+// row's category. It returns the IDs of the matched entries, or an error
+// if any row doesn't match an entry. Rows match by date, type,
+// description and amounts.
+func keepCategories(rows []budget.Transaction, entries []ledger.Entry, transactions []budget.Transaction) ([]string, error) {
+	unmatched := make(map[string][]int)
+	for i, transaction := range transactions {
+		key := rowContent(transaction)
+		unmatched[key] = append(unmatched[key], i)
+	}
+
+	var ids []string
+	var unknown []budget.Transaction
+	for _, row := range rows {
+		key := rowContent(row)
+		if len(unmatched[key]) == 0 {
+			unknown = append(unknown, row)
+			continue
+		}
+
+		i := unmatched[key][0]
+		unmatched[key] = unmatched[key][1:]
+		if row.Category != "" {
+			transactions[i].Category = row.Category
+		}
+		ids = append(ids, entries[i].ID)
+	}
+
+	if len(unknown) > 0 {
+		first := unknown[0]
+		return nil, fmt.Errorf("not cleared, since %d of its rows aren't in the ledger, like %s %q",
+			len(unknown), first.Date.Format("2006-01-02"), first.Description)
+	}
+
+	return ids, nil
+}
+
+// rowContent returns what a worksheet row records of a transaction,
+// besides its category and index, for matching rows with ledger entries.
+func rowContent(transaction budget.Transaction) string {
+	return fmt.Sprintf("%s|%s|%s|%d|%d|%d", transaction.Date.Format("2006-01-02"), transaction.Type,
+		transaction.Description, transaction.DebitPennies, transaction.CreditPennies, transaction.BalancePennies)
+}
@@ -6,87 +6,113 @@
 // more sources, and forwards them one-by-one to one or
 // more destinations. Usually this means fetching from
 // a bank web site and writing to a Google spreadsheet.
+//
+// Usage:
+//
+//	budget-update <command> [options] [arguments]
+//
+// Every command accepts the shared options, such as --config-file,
+// in addition to its own. Run "budget-update <command> -h" for
+// the options of a particular command. With no command, or with
+// only options, budget-update runs the sync command.
 package main
 
 import (
 	"github.com/budney/budget/app"
-	"github.com/budney/budget/budget"
-	"github.com/budney/budget/index"
-	"github.com/budney/google/sheets"
-	"github.com/budney/tdbank"
 
+	"context"
+	"flag"
+	"fmt"
 	"log"
-	"sync"
-	"time"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
-func main() {
-	flags := app.ParseFlags()
-
-	index := getBudgetIndex(flags)
-	srv, _ := sheets.GetService(flags.Sheets.AppSecretFile, flags.Sheets.UserAuthFile)
-	spreadsheet := budget.Spreadsheet{index[len(index)-1], *srv}
-
-	wait := new(sync.WaitGroup)
-	wait.Add(1)
-
-	channel := make(chan budget.Transaction)
-	spreadsheet.AppendFromChannel(channel, wait, "Joint Checking", "Uncategorized")
+// A command is one of budget-update's subcommands.
+type command struct {
+	name    string                                   // The name used on the command line
+	summary string                                   // A one-line description for the usage message
+	run     func(ctx context.Context, args []string) // Runs the command with the arguments following its name
+}
 
-	transactions := getTransactions(flags)
-	for _, v := range transactions {
-		channel <- budget.Transaction(v)
+// commands lists every subcommand, in the order the usage message shows them.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"sync", "Download transactions and append them to the budget", runSync},
+		{"import", "Import transactions from a file", runImport},
+		{"export", "Export transactions from the budget", runExport},
+		{"index", "List the budget spreadsheets in the index", runIndex},
+		{"categorize", "Assign categories to transactions in the budget", runCategorize},
+		{"report", "Print reports about the budget", runReport},
+		{"config", "Check the configuration", runConfig},
+		{"vault", "Manage the encrypted vault of bank logins", runVault},
+		{"ledger", "Query the local ledger, rebuild the budget from it, or drop unfinished appends", runLedger},
+		{"undo", "Delete the rows appended by a sync run", runUndo},
+		{"help", "Print this message", func(context.Context, []string) { usage(); os.Exit(0) }},
 	}
-
-	close(channel)
-	wait.Wait()
 }
 
-func getTransactions(flags app.Flags) []tdbank.HistoryRecord {
-	var client tdbank.Client
-	client.Start()
-	defer client.Stop()
-
-	auth := tdbank.Auth{
-		LoginUrl:          flags.Bank.LoginUrl,
-		Username:          flags.Bank.Username,
-		Password:          flags.Bank.Password,
-		SecurityQuestions: flags.Bank.SecurityQuestions,
+func main() {
+	args := os.Args[1:]
+
+	// Ctrl-C or a TERM signal cancels the command's context, so it can
+	// stop cleanly. A second signal kills the program as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// Running with no command, or only options, means sync
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		runSync(ctx, args)
+		return
 	}
-	client.Login(auth)
-
-	start := time.Date(2017, time.December, 31, 0, 0, 0, 0, time.Local)
-	end := time.Date(2018, time.January, 10, 0, 0, 0, 0, time.Local)
-	log.Printf("Date range: %s - %s", start.Format("01/02/2006"), end.Format("01/02/2006"))
 
-	client.DownloadAccountHistory("Joint Checking", start, end)
-	history, err := client.ParseAccountHistory()
-	if err != nil {
-		log.Fatalf("Failed to read history: %s", err)
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			cmd.run(ctx, args[1:])
+			return
+		}
 	}
 
-	return history
+	fmt.Fprintf(os.Stderr, "budget-update: unknown command %q\n", args[0])
+	usage()
+	os.Exit(2)
 }
 
-func getBudgetIndex(flags app.Flags) []index.Record {
-	srv, err := sheets.GetService(flags.Sheets.AppSecretFile, flags.Sheets.UserAuthFile)
-	if err != nil {
-		log.Fatalf("Couldn't initialize sheets service: %s", err)
+// usage prints a list of the commands.
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: budget-update <command> [options] [arguments]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s%s\n", cmd.name, cmd.summary)
 	}
+}
 
-	index, err := index.FromGoogleSheet(srv, flags.Sheets.IndexSheetId)
+// parseFlags parses a command's arguments along with the shared options,
+// and dies if the configuration can't be loaded.
+func parseFlags(fs *flag.FlagSet, args []string) app.Flags {
+	flags, err := app.ParseFlagSet(fs, args)
 	if err != nil {
-		log.Fatalf("Couldn't read budget index: %s", err)
+		log.Fatalf("Couldn't read configuration: %s", err)
 	}
 
-	/*
-		values := [][]interface{}{{"A", "B", "C", "3.14", "E"}}
-		valuerange := &google.ValueRange{Range: "A1:E", MajorDimension: "ROWS", Values: values}
-		_, err = srv.Spreadsheets.Values.Append(flags.Sheets.IndexSheetId, "A1:E", valuerange).ValueInputOption("USER_ENTERED").Do()
-		if err != nil {
-			log.Fatalf("Couldn't append stuff: %s", err)
-		}
-	*/
+	return flags
+}
+
+// newFlagSet returns a flag set for the named command, whose usage
+// message shows how to run the command.
+func newFlagSet(name string, arguments string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: budget-update %s [options] %s\n\nOptions:\n", name, arguments)
+		fs.PrintDefaults()
+	}
 
-	return index
+	return fs
 }
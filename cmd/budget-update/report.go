@@ -0,0 +1,170 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/budney/budget/app"
+	"github.com/budney/budget/budget"
+	"github.com/budney/budget/index"
+	"github.com/budney/budget/report"
+)
+
+// runReport implements the report subcommands, which summarize the
+// transactions in the budget spreadsheets.
+func runReport(ctx context.Context, args []string) {
+	var dates app.DateRange
+	fs := newFlagSet("report", "spending | variance | subscriptions")
+	dates.AddFlags(fs, "this-year", "today")
+	format := fs.String("format", "table", "The output `format`: "+strings.Join(report.Formats, ", "))
+	number := fs.Int("record", 0, "For variance, the index `number` of the budget spreadsheet (default: the one covering today)")
+	writeSummary := fs.Bool("write-summary", false, "For variance, also write the results to the spreadsheet's "+budget.SummaryWorksheet+" worksheet")
+	tolerance := fs.Float64("tolerance", report.DefaultTolerance, "For subscriptions, how much a payment may change from the last, as a `fraction`")
+	flags := parseFlags(fs, args)
+	args = fs.Args()
+
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	start, end, err := dates.Resolve(time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch args[0] {
+	case "spending":
+		transactions := readBudget(ctx, flags, start, end)
+		err = report.NewSpending(transactions, start, end).Write(os.Stdout, *format)
+	case "variance":
+		err = reportVariance(ctx, flags, *number, *writeSummary, *format)
+	case "subscriptions":
+		// Without --since, look through the whole history
+		since := false
+		fs.Visit(func(f *flag.Flag) { since = since || f.Name == "since" })
+		if !since {
+			for _, record := range getBudgetIndex(ctx, flags) {
+				if record.Start.Before(start) {
+					start = record.Start
+				}
+			}
+		}
+		err = reportSubscriptions(ctx, flags, start, end, *tolerance, *format)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// reportVariance compares the budget targets of a budget spreadsheet's
+// period with the spending recorded in it, and prints the results. The
+// spreadsheet's Budget worksheet, if it has one, overrides the targets
+// in the config file.
+func reportVariance(ctx context.Context, flags app.Flags, number int, writeSummary bool, format string) error {
+	records := getBudgetIndex(ctx, flags)
+	if len(records) == 0 {
+		return errors.New("the budget index is empty")
+	}
+
+	var record index.Record
+	var ok bool
+	if number != 0 {
+		if record, ok = findRecord(records, func(record index.Record) bool { return record.Index == number }); !ok {
+			return fmt.Errorf("there's no budget spreadsheet number %d in the index", number)
+		}
+	} else if record, ok = index.Covering(records, time.Now()); !ok {
+		record = records[len(records)-1]
+	}
+
+	limiter := budget.NewWriteLimiter(flags.Sheets.WritesPerMinute, flags.Sheets.WriteBurst)
+	spreadsheet := newSpreadsheet(flags, record, limiter)
+
+	targets := make(map[string]int64)
+	for category, dollars := range flags.Targets {
+		targets[category] = int64(math.Round(dollars * 100))
+	}
+	sheetTargets, err := spreadsheet.ReadTargets(ctx)
+	var rowErrors budget.RowErrors
+	if err != nil && !errors.As(err, &rowErrors) {
+		return err
+	}
+	for category, pennies := range sheetTargets {
+		targets[category] = pennies
+	}
+
+	variance := report.NewVariance(readSpreadsheet(ctx, flags, &spreadsheet), targets, record.Start, record.End)
+	if err = variance.Write(os.Stdout, format); err != nil {
+		return err
+	}
+
+	if writeSummary {
+		return spreadsheet.WriteWorksheet(ctx, budget.SummaryWorksheet, variance.Rows())
+	}
+	return nil
+}
+
+// reportSubscriptions finds the recurring payments in the budget between
+// start and end, and prints them, with subscriptions that stopped or rose
+// as of end flagged. Payees come from the pipeline's normalize-payee
+// stage, if it has one.
+func reportSubscriptions(ctx context.Context, flags app.Flags, start time.Time, end time.Time, tolerance float64, format string) error {
+	transactions := between(readBudget(ctx, flags, start, end), start, end)
+	if err := normalizePayees(flags, transactions); err != nil {
+		return err
+	}
+
+	return report.NewSubscriptions(transactions, end, tolerance).Write(os.Stdout, format)
+}
+
+// readBudget reads the transactions of every configured account from
+// every budget spreadsheet whose dates overlap start and end.
+func readBudget(ctx context.Context, flags app.Flags, start time.Time, end time.Time) []budget.Transaction {
+	var transactions []budget.Transaction
+
+	for _, record := range index.FilterOverlapping(getBudgetIndex(ctx, flags), start, end) {
+		spreadsheet := newSpreadsheet(flags, record, nil)
+		transactions = append(transactions, readSpreadsheet(ctx, flags, &spreadsheet)...)
+	}
+
+	return transactions
+}
+
+// readSpreadsheet reads the transactions of every configured account
+// from a budget spreadsheet, labeled with their accounts. Only the
+// accounts given with --account, or in the config file, are read. Rows
+// that can't be read are logged and skipped.
+func readSpreadsheet(ctx context.Context, flags app.Flags, spreadsheet *budget.Spreadsheet) []budget.Transaction {
+	var transactions []budget.Transaction
+
+	for _, account := range flags.Bank.Accounts {
+		read, err := spreadsheet.ReadTransactions(ctx, flags.Worksheet(account))
+
+		var rowErrors budget.RowErrors
+		if err != nil && !errors.As(err, &rowErrors) {
+			log.Fatalf("Couldn't read %s: %s", spreadsheet.Filename, err)
+		}
+
+		for _, transaction := range read {
+			transaction.Account = account
+			transactions = append(transactions, transaction)
+		}
+	}
+
+	return transactions
+}
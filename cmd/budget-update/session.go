@@ -0,0 +1,199 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/budney/budget/app"
+	"github.com/budney/budget/budget"
+	"github.com/budney/budget/index"
+	"github.com/budney/budget/ledger"
+	"github.com/budney/budget/pipeline"
+)
+
+// A session appends transactions from one source to the budget
+// spreadsheets, each to the spreadsheet whose period covers its date.
+// Everything it sees is recorded in the ledger, and its appends are
+// journaled and recorded as a run, so that they can be resumed if it
+// dies, or undone.
+type session struct {
+	flags        app.Flags
+	source       string
+	book         *ledger.Ledger
+	records      []index.Record        // The whole index
+	spreadsheets []*budget.Spreadsheet // The spreadsheets covering the session's dates, in index order
+	run          ledger.Run            // With a RunRecord per spreadsheet, in the same order
+	appended     []int                 // How many transactions were appended to each spreadsheet
+	results      []budget.AppendResult
+}
+
+// startSession opens the ledger, finishes any appends an earlier run
+// left unfinished, and starts a run. It dies if the budget index doesn't
+// cover start to end, or the ledger can't be used. The caller must close
+// the session.
+func startSession(ctx context.Context, flags app.Flags, source string, start time.Time, end time.Time) *session {
+	records := getBudgetIndex(ctx, flags)
+	checkCoverage(records, start, end)
+	limiter := budget.NewWriteLimiter(flags.Sheets.WritesPerMinute, flags.Sheets.WriteBurst)
+
+	s := &session{flags: flags, source: source, records: records}
+
+	var err error
+	if s.book, err = ledger.Open(flags.Sheets.LedgerFile); err != nil {
+		log.Fatalf("Couldn't open ledger: %s", err)
+	}
+
+	// Finish what the last run started, before anything new is appended
+	if err = replayJournal(ctx, flags, records, s.book, limiter); err != nil {
+		log.Fatalf("Couldn't replay unfinished appends; see \"ledger pending\": %s", err)
+	}
+
+	// Record the run, so it can be undone
+	now := time.Now()
+	s.run = ledger.Run{ID: ledger.RunID(now), Started: now}
+	for _, record := range index.FilterOverlapping(records, start, end) {
+		spreadsheet := newSpreadsheet(flags, record, limiter)
+		s.spreadsheets = append(s.spreadsheets, &spreadsheet)
+		s.run.IndexID = record.IndexID
+		s.run.Records = append(s.run.Records, ledger.RunRecord{IndexRow: record.Index, PreviousLastUpdated: record.LastUpdated})
+	}
+	s.appended = make([]int, len(s.spreadsheets))
+	if err = s.book.SaveRun(s.run); err != nil {
+		log.Fatalf("Couldn't record run: %s", err)
+	}
+	for _, spreadsheet := range s.spreadsheets {
+		spreadsheet.Journal = s.book.Journal(s.run.ID, source)
+	}
+
+	return s
+}
+
+// appendAccount gets an account's transactions from fetch, sends them
+// through the pipeline, and appends the ones that aren't already in the
+// account's worksheet, in the spreadsheet covering each one's date.
+func (s *session) appendAccount(ctx context.Context, account string, start time.Time, end time.Time, fetch func(context.Context) ([]budget.Transaction, error)) {
+	worksheet := s.flags.Worksheet(account)
+
+	transactions, err := fetch(ctx)
+	if err != nil {
+		s.results = append(s.results, budget.AppendResult{Worksheet: worksheet, Err: err})
+		return
+	}
+
+	// Ledger IDs come from the description as the source gave it, before
+	// any configured stage tidies it up, and from which of several
+	// identical transactions each one is
+	for i := range transactions {
+		if transactions[i].RawDescription == "" {
+			transactions[i].RawDescription = transactions[i].Description
+		}
+	}
+	pipeline.NumberOccurrences(transactions)
+
+	for i, spreadsheet := range s.spreadsheets {
+		stages, err := newPipeline(s.flags, account, start, end)
+		if err != nil {
+			log.Fatalf("Couldn't configure pipeline: %s", err)
+		}
+
+		// Only this spreadsheet's transactions are appended to it
+		record := spreadsheet.Record
+		stages.Stages = append([]*pipeline.Stage{routeTo(s.records, record)}, stages.Stages...)
+
+		// Record everything in the ledger, and only append what
+		// isn't already in the worksheet
+		projection := s.book.Project(s.source, ledgerTarget(*spreadsheet, worksheet), time.Now())
+		stages.Append(projection.Stage())
+
+		result := appendTransactions(ctx, stages, spreadsheet, worksheet, func(context.Context) ([]budget.Transaction, error) {
+			return transactions, nil
+		})
+		if result.Err == nil {
+			result.Err = projection.Commit()
+		} else if result.Appended > 0 {
+			// Only what the journal confirmed is known to be there
+			confirmed, err := projection.CommitConfirmed(s.run.ID)
+			if err != nil {
+				log.Printf("%s: couldn't mark appended transactions in the ledger: %s", worksheet, err)
+			} else if confirmed < result.Appended {
+				log.Printf("%s: %d of %d appended transactions weren't confirmed by the journal; they may be appended again", worksheet, result.Appended-confirmed, result.Appended)
+			}
+		}
+		s.appended[i] += result.Appended
+		s.results = append(s.results, result)
+
+		for _, counters := range stages.Counters() {
+			log.Printf("%s: %s: %s: in %d, out %d, dropped %d", spreadsheet.Filename, account, counters.Name, counters.In, counters.Out, counters.Dropped)
+		}
+	}
+}
+
+// finish records when each budget spreadsheet was last updated, if
+// anything was appended to it, and prints a summary. It returns true if
+// every append succeeded.
+func (s *session) finish(ctx context.Context) bool {
+	now := time.Now()
+	for i, spreadsheet := range s.spreadsheets {
+		if s.appended[i] == 0 {
+			continue
+		}
+
+		if err := index.SetLastUpdated(ctx, &spreadsheet.Service, spreadsheet.Record, now, s.flags.Retry); err != nil {
+			log.Printf("Couldn't update the index: %s", err)
+			continue
+		}
+		s.run.Records[i].LastUpdated = now
+	}
+	if err := s.book.SaveRun(s.run); err != nil {
+		log.Printf("Couldn't record run: %s", err)
+	}
+	log.Printf("Run %s is done; undo it with \"budget-update undo %s\"", s.run.ID, s.run.ID)
+
+	return printSummary(s.results)
+}
+
+// routeTo returns a stage that drops transactions belonging to any
+// budget spreadsheet but the one with the specified record: each
+// transaction belongs to the spreadsheet covering its date.
+func routeTo(records []index.Record, record index.Record) *pipeline.Stage {
+	return pipeline.NewStage("route", func(transaction *budget.Transaction) bool {
+		covering, ok := index.Covering(records, transaction.Date)
+		return ok && covering.IndexID == record.IndexID && covering.Index == record.Index
+	})
+}
+
+// close closes the session's ledger.
+func (s *session) close() {
+	s.book.Close()
+}
+
+// appendTransactions gets transactions from fetch, sends them through
+// the pipeline, and appends them to the specified worksheet.
+func appendTransactions(ctx context.Context, stages *pipeline.Pipeline, spreadsheet *budget.Spreadsheet, worksheet string, fetch func(context.Context) ([]budget.Transaction, error)) budget.AppendResult {
+	channel := make(chan budget.Transaction)
+	results := spreadsheet.AppendFromChannel(ctx, stages.Run(ctx, channel), worksheet, "Uncategorized")
+
+	transactions, err := fetch(ctx)
+	if err != nil {
+		close(channel)
+		<-results
+		return budget.AppendResult{Spreadsheet: spreadsheet.Filename, Worksheet: worksheet, Err: err}
+	}
+
+send:
+	for _, transaction := range transactions {
+		select {
+		case channel <- transaction:
+		case <-ctx.Done():
+			break send
+		}
+	}
+
+	close(channel)
+	return <-results
+}
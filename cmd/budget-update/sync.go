@@ -0,0 +1,294 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/budney/budget/app"
+	"github.com/budney/budget/budget"
+	"github.com/budney/budget/index"
+	"github.com/budney/budget/ledger"
+	"github.com/budney/budget/pipeline"
+	"github.com/budney/google/sheets"
+	"github.com/budney/tdbank"
+	"golang.org/x/time/rate"
+
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runSync downloads transactions from the bank for each configured
+// account, and appends them to the account's worksheet in the current
+// budget spreadsheet. If it's interrupted, or runs out of time, it stops
+// cleanly. Either way it prints a summary of what was written, and it
+// exits non-zero unless everything was.
+func runSync(ctx context.Context, args []string) {
+	var dates app.DateRange
+	fs := newFlagSet("sync", "")
+	dates.AddFlags(fs, "30d", "today")
+	timeout := fs.Duration("timeout", 0, "Stop after this `duration`, e.g. 10m (default: no limit)")
+	flags := parseFlags(fs, args)
+
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	start, end, err := dates.Resolve(time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	session := startSession(ctx, flags, bankSource(flags), start, end)
+	defer session.close()
+
+	bank, err := loginToBank(ctx, flags)
+	if err != nil {
+		log.Fatalf("Couldn't log in to the bank: %s", err)
+	}
+	defer bank.Stop()
+
+	for _, account := range flags.Bank.Accounts {
+		account := account
+		session.appendAccount(ctx, account, start, end, func(ctx context.Context) ([]budget.Transaction, error) {
+			records, err := getTransactions(ctx, bank, account, start, end)
+			if err != nil {
+				return nil, fmt.Errorf("download failed: %s", err)
+			}
+
+			transactions := make([]budget.Transaction, len(records))
+			for i, record := range records {
+				transactions[i] = fromHistory(record)
+			}
+			return transactions, nil
+		})
+	}
+
+	if !session.finish(ctx) {
+		os.Exit(1)
+	}
+}
+
+// newSpreadsheet returns the budget spreadsheet for an index record,
+// configured from flags. Every spreadsheet in a run should share the
+// same limiter.
+func newSpreadsheet(flags app.Flags, record index.Record, limiter *rate.Limiter) budget.Spreadsheet {
+	srv, err := sheets.GetService(flags.Sheets.AppSecretFile, flags.Sheets.UserAuthFile)
+	if err != nil {
+		log.Fatalf("Couldn't initialize sheets service: %s", err)
+	}
+
+	return budget.Spreadsheet{
+		Record:    record,
+		Service:   *srv,
+		Retry:     flags.Retry,
+		ChunkSize: flags.Sheets.ChunkSize,
+		Limiter:   limiter,
+	}
+}
+
+// replayJournal appends the batches that earlier runs planned but never
+// committed, unless they turn out to be in their worksheets already, and
+// commits them. Batches that Google refuses are aborted, and their
+// transactions are sent again as usual. Use "ledger drop" to give up on
+// any other batch that can't be replayed.
+func replayJournal(ctx context.Context, flags app.Flags, records []index.Record, book *ledger.Ledger, limiter *rate.Limiter) error {
+	pending, err := book.Pending()
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range pending {
+		record, ok := findRecord(records, func(record index.Record) bool { return record.SpreadsheetID == batch.SpreadsheetID })
+		if !ok {
+			log.Printf("Skipping batch %s of run %s: spreadsheet %s isn't in the index", batch.ID, batch.Run, batch.SpreadsheetID)
+			continue
+		}
+		spreadsheet := newSpreadsheet(flags, record, limiter)
+
+		log.Printf("%s: %s: replaying %d transactions from run %s", spreadsheet.Filename, batch.Worksheet, len(batch.Rows), batch.Run)
+		updatedRange, err := spreadsheet.Replay(ctx, batch.Worksheet, batch.Rows)
+
+		// A batch that can't be appended is given up on, rather than
+		// blocking every sync after this one
+		var notAppended budget.NotAppendedError
+		if errors.As(err, &notAppended) && ctx.Err() == nil {
+			log.Printf("%s: %s: giving up on batch %s of run %s: %s", spreadsheet.Filename, batch.Worksheet, batch.ID, batch.Run, err)
+			if err = book.AbortBatch(batch.ID, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = book.CommitBatch(batch.ID, updatedRange); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bankSource names the bank as a source of transactions in the ledger.
+func bankSource(flags app.Flags) string {
+	return "tdbank:" + flags.Bank.Institution
+}
+
+// ledgerTarget names a worksheet as a projection target in the ledger.
+func ledgerTarget(spreadsheet budget.Spreadsheet, worksheet string) string {
+	return ledger.Target(spreadsheet.SpreadsheetID, worksheet)
+}
+
+// newPipeline assembles the pipeline for one account: the transactions
+// are labeled with the account, filtered by date, and then sent through
+// the stages from the config file.
+func newPipeline(flags app.Flags, account string, start time.Time, end time.Time) (*pipeline.Pipeline, error) {
+	configured, err := pipeline.FromConfig(flags.Pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	stages := pipeline.New(
+		pipeline.Enrich("label-account", func(transaction *budget.Transaction) { transaction.Account = account }),
+		pipeline.DateFilter(start, end),
+	)
+	stages.Append(configured.Stages...)
+
+	return stages, nil
+}
+
+// fromHistory converts a transaction downloaded from the bank.
+func fromHistory(record tdbank.HistoryRecord) budget.Transaction {
+	return budget.Transaction{
+		Index:          record.Index,
+		Date:           record.Date,
+		Type:           record.Type,
+		Description:    record.Description,
+		DebitPennies:   record.DebitPennies,
+		CreditPennies:  record.CreditPennies,
+		BalancePennies: record.BalancePennies,
+	}
+}
+
+// appended returns the total number of transactions appended.
+func appended(results []budget.AppendResult) int {
+	total := 0
+	for _, result := range results {
+		total += result.Appended
+	}
+
+	return total
+}
+
+// printSummary prints the result of appending to each worksheet, and
+// returns true if they all succeeded.
+func printSummary(results []budget.AppendResult) bool {
+	ok := true
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SPREADSHEET\tWORKSHEET\tRECEIVED\tAPPENDED\tSTATUS")
+	for _, result := range results {
+		status := "OK"
+		if result.Err != nil {
+			status = result.Err.Error()
+			ok = false
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", result.Spreadsheet, result.Worksheet, result.Received, result.Appended, status)
+	}
+	w.Flush()
+
+	return ok
+}
+
+// loginToBank starts a bank client and logs in. The caller must stop
+// the client when done.
+func loginToBank(ctx context.Context, flags app.Flags) (*tdbank.Client, error) {
+	if err := flags.ResolvePassword(); err != nil {
+		return nil, fmt.Errorf("couldn't look up bank password: %s", err)
+	}
+	if err := app.UseVault(&flags.Bank); err != nil {
+		return nil, fmt.Errorf("couldn't read bank login from vault: %s", err)
+	}
+
+	client := new(tdbank.Client)
+	client.Start()
+
+	auth := tdbank.Auth{
+		LoginUrl:          flags.Bank.LoginURL,
+		Username:          flags.Bank.Username,
+		Password:          flags.Bank.Password,
+		SecurityQuestions: flags.Bank.SecurityQuestions,
+	}
+	client.Login(auth)
+
+	if err := ctx.Err(); err != nil {
+		client.Stop()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// getTransactions downloads an account's transactions between start and
+// end, inclusive. The bank client can't be interrupted, so ctx is checked
+// between steps.
+func getTransactions(ctx context.Context, client *tdbank.Client, account string, start time.Time, end time.Time) ([]tdbank.HistoryRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("%s: date range: %s - %s", account, start.Format("01/02/2006"), end.Format("01/02/2006"))
+
+	client.DownloadAccountHistory(account, start, end)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return client.ParseAccountHistory()
+}
+
+// checkCoverage dies unless every date between start and end is covered
+// by some budget spreadsheet in the index, since transactions on other
+// dates would have nowhere to go.
+func checkCoverage(records []index.Record, start time.Time, end time.Time) {
+	gaps := index.Gaps(records, start, end)
+	for _, gap := range gaps {
+		log.Printf("No budget spreadsheet covers %s - %s", gap.Start.Format("01/02/2006"), gap.End.Format("01/02/2006"))
+	}
+
+	if len(gaps) > 0 {
+		log.Fatal("Add budget spreadsheets to the index, or choose different dates with --since and --until")
+	}
+}
+
+func getBudgetIndex(ctx context.Context, flags app.Flags) []index.Record {
+	srv, err := sheets.GetService(flags.Sheets.AppSecretFile, flags.Sheets.UserAuthFile)
+	if err != nil {
+		log.Fatalf("Couldn't initialize sheets service: %s", err)
+	}
+
+	index, err := index.FromGoogleSheet(ctx, srv, flags.Sheets.IndexSheetID, flags.Retry)
+	if err != nil {
+		log.Fatalf("Couldn't read budget index: %s", err)
+	}
+
+	/*
+		values := [][]interface{}{{"A", "B", "C", "3.14", "E"}}
+		valuerange := &google.ValueRange{Range: "A1:E", MajorDimension: "ROWS", Values: values}
+		_, err = srv.Spreadsheets.Values.Append(flags.Sheets.IndexSheetID, "A1:E", valuerange).ValueInputOption("USER_ENTERED").Do()
+		if err != nil {
+			log.Fatalf("Couldn't append stuff: %s", err)
+		}
+	*/
+
+	return index
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/budney/budget/budget"
+	"github.com/budney/budget/index"
+	"github.com/budney/budget/ledger"
+)
+
+// runUndo deletes the rows a sync run appended, and puts the index back
+// the way it was; for a rebuild, it also puts back the rows it cleared.
+// With no run ID, it undoes the latest run that hasn't been undone
+// already. Runs must be undone latest first: deleting an earlier run's
+// rows moves the rows later runs appended, and rolling back the index
+// would undo their updates too. It refuses to undo any other run
+// without --force.
+func runUndo(ctx context.Context, args []string) {
+	fs := newFlagSet("undo", "[run-id]")
+	list := fs.Bool("list", false, "List the runs that can be undone, instead of undoing one")
+	force := fs.Bool("force", false, "Undo the run even if it isn't the latest")
+	flags := parseFlags(fs, args)
+	args = fs.Args()
+
+	if len(args) > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	book, err := ledger.Open(flags.Sheets.LedgerFile)
+	if err != nil {
+		log.Fatalf("Couldn't open ledger: %s", err)
+	}
+	defer book.Close()
+
+	if *list {
+		listRuns(book)
+		return
+	}
+
+	last, err := book.LastRun()
+	run := last
+	if len(args) == 1 {
+		run, err = book.GetRun(args[0])
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !run.Undone.IsZero() {
+		log.Fatalf("Run %s was already undone at %s", run.ID, run.Undone.Format("2006-01-02 15:04"))
+	}
+	if run.ID != last.ID && !*force {
+		log.Fatalf("Run %s isn't the latest run; undo %s first, or use --force", run.ID, last.ID)
+	}
+
+	batches, err := book.Batches(run.ID)
+	if err != nil {
+		log.Fatalf("Couldn't read the journal: %s", err)
+	}
+
+	records := getBudgetIndex(ctx, flags)
+	limiter := budget.NewWriteLimiter(flags.Sheets.WritesPerMinute, flags.Sheets.WriteBurst)
+
+	// Delete the last rows first, so the earlier ranges don't move
+	for i := len(batches) - 1; i >= 0; i-- {
+		batch := batches[i]
+
+		record, ok := findRecord(records, func(record index.Record) bool { return record.SpreadsheetID == batch.SpreadsheetID })
+		if !ok {
+			log.Fatalf("Spreadsheet %s isn't in the index", batch.SpreadsheetID)
+		}
+		spreadsheet := newSpreadsheet(flags, record, limiter)
+
+		if err = spreadsheet.DeleteRows(ctx, batch.Worksheet, batch.UpdatedRange, batch.Rows); err != nil {
+			log.Fatalf("Couldn't undo run %s: %s", run.ID, err)
+		}
+		if err = book.UndoBatch(batch.ID); err != nil {
+			log.Fatalf("Couldn't record undo of %s: %s", batch.UpdatedRange, err)
+		}
+	}
+
+	for _, updated := range run.Records {
+		if updated.LastUpdated.IsZero() {
+			continue
+		}
+
+		record, ok := findRecord(records, func(record index.Record) bool {
+			return record.IndexID == run.IndexID && record.Index == updated.IndexRow
+		})
+		if !ok {
+			log.Fatalf("Index record %d isn't in the index", updated.IndexRow)
+		}
+
+		spreadsheet := newSpreadsheet(flags, record, limiter)
+		if err = index.SetLastUpdated(ctx, &spreadsheet.Service, record, updated.PreviousLastUpdated, flags.Retry); err != nil {
+			log.Fatalf("Couldn't roll back the index: %s", err)
+		}
+	}
+
+	// Put back what a rebuild cleared, now that its rows are gone
+	for _, cleared := range run.Cleared {
+		record, ok := findRecord(records, func(record index.Record) bool { return record.SpreadsheetID == cleared.SpreadsheetID })
+		if !ok {
+			log.Fatalf("Spreadsheet %s isn't in the index", cleared.SpreadsheetID)
+		}
+		spreadsheet := newSpreadsheet(flags, record, limiter)
+
+		if _, err = spreadsheet.AppendArray(ctx, cleared.Transactions, cleared.Worksheet, ""); err != nil {
+			log.Fatalf("Couldn't restore %s: %s", cleared.Worksheet, err)
+		}
+		if err = book.MarkProjected(cleared.EntryIDs, ledger.Target(cleared.SpreadsheetID, cleared.Worksheet)); err != nil {
+			log.Fatalf("Couldn't record restore of %s: %s", cleared.Worksheet, err)
+		}
+	}
+
+	run.Undone = time.Now()
+	if err = book.SaveRun(run); err != nil {
+		log.Fatalf("Couldn't record undo of run %s: %s", run.ID, err)
+	}
+	log.Printf("Undid run %s: deleted %d batches of transactions", run.ID, len(batches))
+}
+
+// listRuns prints every run in the ledger, with how many batches of
+// rows it appended.
+func listRuns(book *ledger.Ledger) {
+	runs, err := book.Runs()
+	if err != nil {
+		log.Fatalf("Couldn't read runs: %s", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tSTARTED\tBATCHES\tUNDONE")
+	for _, run := range runs {
+		batches, err := book.Batches(run.ID)
+		if err != nil {
+			log.Fatalf("Couldn't read the journal: %s", err)
+		}
+
+		undone := ""
+		if !run.Undone.IsZero() {
+			undone = run.Undone.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", run.ID, run.Started.Format("2006-01-02 15:04"), len(batches), undone)
+	}
+	w.Flush()
+}
+
+// findRecord returns the first index record that passes the test.
+func findRecord(records []index.Record, test func(index.Record) bool) (index.Record, bool) {
+	if found := index.Filter(records, test); len(found) > 0 {
+		return found[0], true
+	}
+
+	return index.Record{}, false
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/budney/budget/app"
+	"github.com/budney/budget/vault"
+	"golang.org/x/term"
+)
+
+// runVault implements the vault subcommands, which manage the
+// encrypted store of bank logins.
+func runVault(ctx context.Context, args []string) {
+	fs := newFlagSet("vault", "add|remove <institution> | list")
+	flags := parseFlags(fs, args)
+	args = fs.Args()
+
+	switch {
+	case len(args) == 2 && args[0] == "add":
+		vaultAdd(flags, args[1])
+	case len(args) == 1 && args[0] == "list":
+		vaultList(flags)
+	case len(args) == 2 && args[0] == "remove":
+		vaultRemove(flags, args[1])
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// vaultAdd prompts for the credentials for an institution, and adds or
+// replaces its entry in the vault.
+func vaultAdd(flags app.Flags, name string) {
+	_, err := os.Stat(flags.Bank.VaultFile)
+	v, err := app.OpenVault(flags.Bank, os.IsNotExist(err))
+	if err != nil {
+		log.Fatalf("Couldn't open vault: %s", err)
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	entry := vault.Entry{SecurityQuestions: make(map[string]string)}
+
+	entry.Username = prompt(stdin, "Username: ")
+	entry.Password = promptSecret(stdin, "Password: ")
+
+	// Security questions are entered until a blank question
+	for {
+		question := prompt(stdin, "Security question (blank when done): ")
+		if question == "" {
+			break
+		}
+		entry.SecurityQuestions[question] = prompt(stdin, "Answer: ")
+	}
+
+	v.Add(name, entry)
+	if err = v.Save(); err != nil {
+		log.Fatalf("Couldn't save vault: %s", err)
+	}
+}
+
+// vaultList prints the names of the institutions in the vault, along with
+// their usernames. It never prints passwords or answers.
+func vaultList(flags app.Flags) {
+	v, err := app.OpenVault(flags.Bank, false)
+	if err != nil {
+		log.Fatalf("Couldn't open vault: %s", err)
+	}
+
+	for _, name := range v.Names() {
+		entry, _ := v.Lookup(name)
+		fmt.Printf("%s\t%s\t(%d security questions)\n", name, entry.Username, len(entry.SecurityQuestions))
+	}
+}
+
+// vaultRemove deletes an institution's entry from the vault.
+func vaultRemove(flags app.Flags, name string) {
+	v, err := app.OpenVault(flags.Bank, false)
+	if err != nil {
+		log.Fatalf("Couldn't open vault: %s", err)
+	}
+
+	if err = v.Remove(name); err != nil {
+		log.Fatal(err)
+	}
+	if err = v.Save(); err != nil {
+		log.Fatalf("Couldn't save vault: %s", err)
+	}
+}
+
+// promptSecret is like prompt, but doesn't echo the line if stdin is a
+// terminal. A terminal returns one line per read, so stdin hasn't
+// buffered anything past the last line read; when the input is piped
+// in, it's read from stdin, since the pipe's next lines may already be
+// buffered there.
+func promptSecret(stdin *bufio.Reader, text string) string {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return prompt(stdin, text)
+	}
+
+	secret, err := app.ReadSecret(text)
+	if err != nil {
+		log.Fatalf("Couldn't read input: %s", err)
+	}
+
+	return string(secret)
+}
+
+// prompt prints a prompt on stderr and reads a line from stdin.
+func prompt(stdin *bufio.Reader, text string) string {
+	fmt.Fprint(os.Stderr, text)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatalf("Couldn't read input: %s", err)
+	}
+
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,246 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package export
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/budney/budget/budget"
+	"github.com/budney/budget/pipeline"
+)
+
+// BeancountOptions controls the files written by WriteBeancount.
+type BeancountOptions struct {
+	Accounts Accounts // How transactions map to Beancount accounts
+	Currency string   // The currency of transactions that don't say, e.g. "USD"
+	Balances bool     // Whether to write balance directives
+}
+
+// DefaultBeancountOptions writes US dollars, with balance directives.
+var DefaultBeancountOptions = BeancountOptions{Accounts: DefaultAccounts, Currency: "USD", Balances: true}
+
+// WriteBeancount writes transactions as a Beancount file. Every account
+// is opened on the first day it's used, for every currency it's used in;
+// each transaction is in its own currency, if it has one. Each transaction's payee is its
+// payee, or its description if it has none, and its narration is its
+// type. It has a txn_id that depends only on its contents, so exporting
+// the same transactions again gives the same file, and value_date and
+// reference metadata if it has a value date and reference.
+//
+// With Balances, each bank account's balance at the end of each day is
+// asserted by a balance directive dated the next day, since Beancount
+// checks balances at the start of the day. A zero balance is taken to be
+// unknown, as it is for QIF imports, and isn't asserted.
+//
+//	2018-01-03 balance Assets:Checking  129.77 USD
+//
+//	2018-01-03 * "Paycheck" "ACH"
+//	  txn_id: "3f2a9c0d1b7e4a65"
+//	  Income:Salary     -1000.00 USD
+//	  Assets:Checking    1000.00 USD
+func WriteBeancount(w io.Writer, transactions []budget.Transaction, options BeancountOptions) error {
+	out := bufio.NewWriter(w)
+	transactions = sorted(transactions)
+
+	fmt.Fprintf(out, "option \"operating_currency\" %s\n", strconv.Quote(options.Currency))
+
+	// Open every account on the first day it's used, with every currency
+	// it's used in
+	type open struct {
+		date       string
+		account    string
+		currencies []string
+	}
+	var opens []*open
+	opened := make(map[string]*open)
+	for _, transaction := range transactions {
+		currency := options.currency(transaction)
+		for _, account := range []string{options.asset(transaction), options.category(transaction)} {
+			o := opened[account]
+			if o == nil {
+				o = &open{date: transaction.Date.Format("2006-01-02"), account: account}
+				opened[account] = o
+				opens = append(opens, o)
+			}
+			if !contains(o.currencies, currency) {
+				o.currencies = append(o.currencies, currency)
+			}
+		}
+	}
+	if len(opens) > 0 {
+		fmt.Fprintln(out)
+		for _, o := range opens {
+			fmt.Fprintf(out, "%s open %s %s\n", o.date, o.account, strings.Join(o.currencies, ","))
+		}
+	}
+
+	// Balances are due the morning after each day's last transaction
+	balances := make(map[string][]string)
+	if options.Balances {
+		type key struct {
+			date     string
+			account  string
+			currency string
+		}
+		last := make(map[key]budget.Transaction)
+		var keys []key
+		for _, transaction := range transactions {
+			k := key{transaction.Date.AddDate(0, 0, 1).Format("2006-01-02"), options.asset(transaction), options.currency(transaction)}
+			if _, ok := last[k]; !ok {
+				keys = append(keys, k)
+			}
+			last[k] = transaction
+		}
+
+		for _, k := range keys {
+			if last[k].BalancePennies == 0 {
+				continue
+			}
+			balances[k.date] = append(balances[k.date], fmt.Sprintf("%s balance %s  %s %s", k.date, k.account, decimal(last[k].BalancePennies), k.currency))
+		}
+	}
+
+	// Write each day's balances, and then its transactions
+	var days []string
+	seen := make(map[string]bool)
+	for _, transaction := range transactions {
+		if day := transaction.Date.Format("2006-01-02"); !seen[day] {
+			seen[day] = true
+			days = append(days, day)
+		}
+	}
+	for day := range balances {
+		if !seen[day] {
+			seen[day] = true
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+
+	byDay := make(map[string][]budget.Transaction)
+	for _, transaction := range transactions {
+		day := transaction.Date.Format("2006-01-02")
+		byDay[day] = append(byDay[day], transaction)
+	}
+
+	for _, day := range days {
+		if len(balances[day]) > 0 {
+			fmt.Fprintln(out)
+			for _, balance := range balances[day] {
+				fmt.Fprintln(out, balance)
+			}
+		}
+
+		for _, transaction := range byDay[day] {
+			fmt.Fprintln(out)
+			options.writeTransaction(out, transaction)
+		}
+	}
+
+	return out.Flush()
+}
+
+// writeTransaction writes one transaction and its postings.
+func (options BeancountOptions) writeTransaction(out io.Writer, transaction budget.Transaction) {
+	fmt.Fprintf(out, "%s * %s %s\n", transaction.Date.Format("2006-01-02"),
+		strconv.Quote(oneLine(payee(transaction))), strconv.Quote(oneLine(transaction.Type)))
+	fmt.Fprintf(out, "  txn_id: %s\n", strconv.Quote(TransactionID(transaction)))
+	if transaction.Payee != "" {
+		fmt.Fprintf(out, "  description: %s\n", strconv.Quote(oneLine(transaction.Description)))
+	}
+	if !transaction.ValueDate.IsZero() {
+		fmt.Fprintf(out, "  value_date: %s\n", transaction.ValueDate.Format("2006-01-02"))
+	}
+	if transaction.Reference != "" {
+		fmt.Fprintf(out, "  reference: %s\n", strconv.Quote(oneLine(transaction.Reference)))
+	}
+	currency := options.currency(transaction)
+	fmt.Fprintf(out, "  %-40s  %10s %s\n", options.category(transaction), decimal(-amount(transaction)), currency)
+	fmt.Fprintf(out, "  %-40s  %10s %s\n", options.asset(transaction), decimal(amount(transaction)), currency)
+}
+
+// currency returns the currency of a transaction, or the default
+// currency if it doesn't say.
+func (options BeancountOptions) currency(transaction budget.Transaction) string {
+	if transaction.Currency != "" {
+		return transaction.Currency
+	}
+
+	return options.Currency
+}
+
+// contains reports whether a list of strings includes s.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// asset returns the Beancount account of a transaction's bank account.
+func (options BeancountOptions) asset(transaction budget.Transaction) string {
+	return beancountAccount(options.Accounts.Asset(transaction))
+}
+
+// category returns the Beancount account of a transaction's category.
+func (options BeancountOptions) category(transaction budget.Transaction) string {
+	return beancountAccount(options.Accounts.Category(transaction))
+}
+
+// TransactionID returns an ID for a transaction that depends only on the
+// fields in pipeline.Key, so the same transaction always gets the same
+// ID, whichever spreadsheet it's read from.
+func TransactionID(transaction budget.Transaction) string {
+	sum := sha256.Sum256([]byte(pipeline.Key(transaction)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// beancountAccount makes an account name acceptable to Beancount, whose
+// components must start with a capital letter or a digit, and contain
+// only letters, digits and dashes. "Expenses:eating out" becomes
+// "Expenses:Eating-out".
+func beancountAccount(name string) string {
+	components := strings.Split(name, ":")
+	for i, component := range components {
+		var b strings.Builder
+		dash := false
+		for _, r := range component {
+			switch {
+			case unicode.IsLetter(r) || unicode.IsDigit(r):
+				b.WriteRune(r)
+				dash = false
+			case b.Len() > 0 && !dash:
+				b.WriteRune('-')
+				dash = true
+			}
+		}
+
+		component = strings.TrimRight(b.String(), "-")
+		if component == "" {
+			component = "Unknown"
+		}
+
+		first := []rune(component)[0]
+		if unicode.IsLetter(first) {
+			component = string(unicode.ToUpper(first)) + component[len(string(first)):]
+		} else if !unicode.IsDigit(first) {
+			component = "X" + component
+		}
+		components[i] = component
+	}
+
+	return strings.Join(components, ":")
+}
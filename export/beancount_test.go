@@ -0,0 +1,106 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Test writing a Beancount file
+func TestWriteBeancount(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteBeancount(&b, exportFixture(), DefaultBeancountOptions); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+
+	t.Run("Open", func(t *testing.T) {
+		for _, want := range []string{
+			"2018-01-02 open Assets:Checking USD\n",
+			"2018-01-02 open Expenses:Uncategorized USD\n",
+			"2018-01-03 open Income:Salary USD\n",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("Missing %q in:\n%s", want, out)
+			}
+		}
+	})
+	t.Run("Transaction", func(t *testing.T) {
+		if !strings.Contains(out, "2018-01-02 * \"Coffee  Shop\" \"POS\"\n  txn_id: \"") {
+			t.Errorf("Missing transaction in:\n%s", out)
+		}
+	})
+	t.Run("Balances", func(t *testing.T) {
+		first := strings.Index(out, "2018-01-03 balance Assets:Checking  129.77 USD")
+		paycheck := strings.Index(out, "2018-01-03 * \"Paycheck\"")
+		if first < 0 || paycheck < first {
+			t.Errorf("Expected the balance before the paycheck in:\n%s", out)
+		}
+		if !strings.Contains(out, "2018-01-04 balance Assets:Checking  1129.77 USD") {
+			t.Errorf("Missing final balance in:\n%s", out)
+		}
+	})
+	t.Run("UnknownBalance", func(t *testing.T) {
+		transactions := exportFixture()
+		transactions[0].BalancePennies = 0
+
+		var b bytes.Buffer
+		if err := WriteBeancount(&b, transactions, DefaultBeancountOptions); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(b.String(), "2018-01-04 balance") || !strings.Contains(b.String(), "2018-01-03 balance") {
+			t.Errorf("Expected only the known balance in:\n%s", b.String())
+		}
+	})
+	t.Run("Currency", func(t *testing.T) {
+		transactions := exportFixture()
+		transactions[1].Currency = "EUR"
+
+		var b bytes.Buffer
+		if err := WriteBeancount(&b, transactions, DefaultBeancountOptions); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			"2018-01-02 open Assets:Checking EUR,USD\n",
+			"2018-01-02 open Expenses:Uncategorized EUR\n",
+			"2018-01-03 balance Assets:Checking  129.77 EUR\n",
+			"-5.23 EUR\n",
+			"1000.00 USD\n",
+		} {
+			if !strings.Contains(b.String(), want) {
+				t.Errorf("Missing %q in:\n%s", want, b.String())
+			}
+		}
+	})
+	t.Run("Stable", func(t *testing.T) {
+		var again bytes.Buffer
+		if err := WriteBeancount(&again, exportFixture(), DefaultBeancountOptions); err != nil {
+			t.Fatal(err)
+		}
+		if again.String() != out {
+			t.Error("Exporting twice gave different files")
+		}
+	})
+}
+
+// Test cleaning up account names
+func TestBeancountAccount(t *testing.T) {
+	tests := map[string]string{
+		"Expenses:Food":           "Expenses:Food",
+		"Expenses:eating out":     "Expenses:Eating-out",
+		"Assets:TD Checking #123": "Assets:TD-Checking-123",
+		"Expenses:(misc)":         "Expenses:Misc",
+		"Expenses:":               "Expenses:Unknown",
+		"Expenses:401k":           "Expenses:401k",
+	}
+
+	for name, want := range tests {
+		if got := beancountAccount(name); got != want {
+			t.Errorf("%q: expected %q, got %q", name, want, got)
+		}
+	}
+}
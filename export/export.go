@@ -0,0 +1,92 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package export writes transactions read back out of the budget in
+// formats other programs understand, such as the plain-text journals of
+// ledger-cli and hledger.
+package export
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/budney/budget/budget"
+)
+
+// Accounts says how transactions are mapped to the accounts of a
+// double-entry journal. Each transaction moves money between its bank
+// account, under Assets, and its category, under Expenses for debits
+// or Income for credits.
+type Accounts struct {
+	Assets   string // The parent of bank accounts, e.g. "Assets"
+	Expenses string // The parent of categories that money is spent on
+	Income   string // The parent of categories that money comes from
+}
+
+// DefaultAccounts are the usual top-level account names.
+var DefaultAccounts = Accounts{Assets: "Assets", Expenses: "Expenses", Income: "Income"}
+
+// Uncategorized is the category of transactions that don't have one.
+const Uncategorized = "Uncategorized"
+
+// Asset returns the journal account of a transaction's bank account.
+func (accounts Accounts) Asset(transaction budget.Transaction) string {
+	return join(accounts.Assets, transaction.Account)
+}
+
+// Category returns the journal account of a transaction's category,
+// under Expenses or Income depending on which way the money moved.
+func (accounts Accounts) Category(transaction budget.Transaction) string {
+	category := transaction.Category
+	if category == "" {
+		category = Uncategorized
+	}
+
+	if transaction.CreditPennies > transaction.DebitPennies {
+		return join(accounts.Income, category)
+	}
+	return join(accounts.Expenses, category)
+}
+
+// join makes an account name from a parent and a child, tidying the
+// child's whitespace, which some journal formats treat as a separator.
+func join(parent string, child string) string {
+	child = strings.Join(strings.Fields(child), " ")
+	if child == "" {
+		child = "Unknown"
+	}
+
+	return parent + ":" + child
+}
+
+// sorted returns a copy of the transactions, sorted by date, and then
+// by index within each account.
+func sorted(transactions []budget.Transaction) []budget.Transaction {
+	result := append([]budget.Transaction(nil), transactions...)
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if !a.Date.Equal(b.Date) {
+			return a.Date.Before(b.Date)
+		}
+		return a.Index < b.Index
+	})
+
+	return result
+}
+
+// amount returns the money a transaction added to its bank account, in
+// pennies. It's negative for debits.
+func amount(transaction budget.Transaction) int64 {
+	return transaction.CreditPennies - transaction.DebitPennies
+}
+
+// payee returns a transaction's payee, or its description if it doesn't
+// have one.
+func payee(transaction budget.Transaction) string {
+	if transaction.Payee != "" {
+		return transaction.Payee
+	}
+
+	return transaction.Description
+}
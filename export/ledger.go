@@ -0,0 +1,112 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/budney/budget/budget"
+)
+
+// LedgerOptions controls the journals written by WriteLedger.
+type LedgerOptions struct {
+	Accounts   Accounts // How transactions map to journal accounts
+	Commodity  string   // The currency symbol, e.g. "$"
+	Currency   string   // The ISO 4217 code of the currency Commodity stands for
+	Assertions bool     // Whether to assert the bank balance after each transaction
+}
+
+// DefaultLedgerOptions writes dollars, with balance assertions.
+var DefaultLedgerOptions = LedgerOptions{Accounts: DefaultAccounts, Commodity: "$", Currency: "USD", Assertions: true}
+
+// WriteLedger writes transactions as a journal that both ledger-cli and
+// hledger can read. Transactions are written in date order, each with a
+// posting to its category and one to its bank account. Transactions are
+// titled by their payee, if they have one, and otherwise by their
+// description; a title that starts with "(" follows an empty code, "()",
+// so it isn't read as the transaction's code. With Assertions, the bank
+// posting asserts the balance the bank reported, so a missing or
+// duplicated transaction is caught when the journal is read. A zero
+// balance is taken to be unknown, as it is for QIF imports, and isn't
+// asserted.
+//
+// A transaction's value date, if it has one, is its auxiliary date, and
+// its reference is a tag. Amounts in a currency other than the one
+// Commodity stands for are written with the currency's code instead.
+//
+//	2018-01-02 * Coffee
+//	    ; type: POS
+//	    Expenses:Food           $5.23
+//	    Assets:Checking        $-5.23 = $1234.56
+func WriteLedger(w io.Writer, transactions []budget.Transaction, options LedgerOptions) error {
+	out := bufio.NewWriter(w)
+
+	for i, transaction := range sorted(transactions) {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+
+		title := oneLine(payee(transaction))
+		if strings.HasPrefix(title, "(") {
+			title = "() " + title
+		}
+		date := transaction.Date.Format("2006-01-02")
+		if !transaction.ValueDate.IsZero() && !transaction.ValueDate.Equal(transaction.Date) {
+			date += "=" + transaction.ValueDate.Format("2006-01-02")
+		}
+		fmt.Fprintf(out, "%s * %s\n", date, title)
+		if transaction.Payee != "" {
+			fmt.Fprintf(out, "    ; description: %s\n", oneLine(transaction.Description))
+		}
+		if transaction.Type != "" {
+			fmt.Fprintf(out, "    ; type: %s\n", oneLine(transaction.Type))
+		}
+		if transaction.Reference != "" {
+			fmt.Fprintf(out, "    ; reference: %s\n", oneLine(transaction.Reference))
+		}
+
+		fmt.Fprintf(out, "    %-40s  %s\n", options.Accounts.Category(transaction), options.money(-amount(transaction), transaction.Currency))
+		fmt.Fprintf(out, "    %-40s  %s", options.Accounts.Asset(transaction), options.money(amount(transaction), transaction.Currency))
+		if options.Assertions && transaction.BalancePennies != 0 {
+			fmt.Fprintf(out, " = %s", options.money(transaction.BalancePennies, transaction.Currency))
+		}
+		fmt.Fprintln(out)
+	}
+
+	return out.Flush()
+}
+
+// money formats pennies with the commodity symbol, e.g. "$-5.23", or
+// with a different currency's code, e.g. "-5.23 EUR".
+func (options LedgerOptions) money(pennies int64, currency string) string {
+	if currency != "" && currency != options.Currency {
+		return decimal(pennies) + " " + currency
+	}
+
+	return options.Commodity + decimal(pennies)
+}
+
+// decimal formats pennies as dollars and cents, e.g. "-5.23".
+func decimal(pennies int64) string {
+	sign := ""
+	if pennies < 0 {
+		sign = "-"
+		pennies = -pennies
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, pennies/100, pennies%100)
+}
+
+// oneLine collapses a description onto one line, since a line break
+// would end the transaction.
+func oneLine(s string) string {
+	return newlines.Replace(s)
+}
+
+// newlines replaces line breaks with spaces.
+var newlines = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
@@ -0,0 +1,108 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/budney/budget/budget"
+)
+
+// exportFixture returns a debit and a credit, out of order
+func exportFixture() []budget.Transaction {
+	day := func(d int) time.Time {
+		return time.Date(2018, time.January, d, 0, 0, 0, 0, time.Local)
+	}
+
+	return []budget.Transaction{
+		{Index: 1, Date: day(3), Type: "ACH", Description: "Paycheck", CreditPennies: 100000, BalancePennies: 112977, Account: "Checking", Category: "Salary"},
+		{Index: 1, Date: day(2), Type: "POS", Description: "Coffee  Shop", DebitPennies: 523, BalancePennies: 12977, Account: "Checking"},
+	}
+}
+
+// Test writing a ledger journal
+func TestWriteLedger(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteLedger(&b, exportFixture(), DefaultLedgerOptions); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(b.String(), "\n")
+	t.Run("Order", func(t *testing.T) {
+		if lines[0] != "2018-01-02 * Coffee  Shop" {
+			t.Errorf("Expected the coffee first, got %q", lines[0])
+		}
+	})
+	t.Run("Postings", func(t *testing.T) {
+		if !strings.HasPrefix(lines[2], "    Expenses:Uncategorized ") || !strings.HasSuffix(lines[2], " $5.23") {
+			t.Errorf("Wrong expense posting: %q", lines[2])
+		}
+		if !strings.HasPrefix(lines[3], "    Assets:Checking ") || !strings.HasSuffix(lines[3], " $-5.23 = $129.77") {
+			t.Errorf("Wrong asset posting: %q", lines[3])
+		}
+	})
+	t.Run("Income", func(t *testing.T) {
+		if !strings.Contains(b.String(), "Income:Salary") || !strings.Contains(b.String(), "$-1000.00\n") {
+			t.Errorf("Wrong income posting:\n%s", b.String())
+		}
+	})
+	t.Run("NoAssertions", func(t *testing.T) {
+		options := DefaultLedgerOptions
+		options.Assertions = false
+
+		var b bytes.Buffer
+		if err := WriteLedger(&b, exportFixture(), options); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(b.String(), " = ") {
+			t.Errorf("Expected no assertions:\n%s", b.String())
+		}
+	})
+	t.Run("UnknownBalance", func(t *testing.T) {
+		transactions := exportFixture()
+		transactions[1].BalancePennies = 0
+
+		var b bytes.Buffer
+		if err := WriteLedger(&b, transactions, DefaultLedgerOptions); err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Split(b.String(), "\n"); strings.Contains(lines[3], " = ") || !strings.Contains(b.String(), " = $1129.77") {
+			t.Errorf("Expected only the known balance asserted:\n%s", b.String())
+		}
+	})
+	t.Run("ValueDate", func(t *testing.T) {
+		transactions := exportFixture()
+		transactions[1].ValueDate = transactions[1].Date.AddDate(0, 0, 1)
+		transactions[1].Reference = "TX123"
+		transactions[1].Currency = "EUR"
+
+		var b bytes.Buffer
+		if err := WriteLedger(&b, transactions, DefaultLedgerOptions); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(b.String(), "\n")
+		if lines[0] != "2018-01-02=2018-01-03 * Coffee  Shop" || !strings.Contains(b.String(), "; reference: TX123\n") {
+			t.Errorf("Expected a value date and reference:\n%s", b.String())
+		}
+		if !strings.Contains(b.String(), " -5.23 EUR = 129.77 EUR\n") || !strings.Contains(b.String(), " $1000.00 = $1129.77\n") {
+			t.Errorf("Expected euros only for the coffee:\n%s", b.String())
+		}
+	})
+	t.Run("Parenthesis", func(t *testing.T) {
+		transactions := exportFixture()
+		transactions[1].Description = "(PENDING) Coffee"
+
+		var b bytes.Buffer
+		if err := WriteLedger(&b, transactions, DefaultLedgerOptions); err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Split(b.String(), "\n"); lines[0] != "2018-01-02 * () (PENDING) Coffee" {
+			t.Errorf("Expected an empty code, got %q", lines[0])
+		}
+	})
+}
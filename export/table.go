@@ -0,0 +1,198 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/budney/budget/budget"
+)
+
+// A Column is one field of a transaction, for the tabular formats.
+type Column struct {
+	Name  string                               // The name used in headers, keys and --columns
+	Value func(budget.Transaction) interface{} // The field's value; amounts are in dollars
+}
+
+// Columns lists every column, in the default order.
+var Columns = []Column{
+	{"date", func(t budget.Transaction) interface{} { return t.Date.Format("2006-01-02") }},
+	{"account", func(t budget.Transaction) interface{} { return t.Account }},
+	{"index", func(t budget.Transaction) interface{} { return t.Index }},
+	{"type", func(t budget.Transaction) interface{} { return t.Type }},
+	{"description", func(t budget.Transaction) interface{} { return t.Description }},
+	{"payee", func(t budget.Transaction) interface{} { return t.Payee }},
+	{"location", func(t budget.Transaction) interface{} { return t.Location }},
+	{"card", func(t budget.Transaction) interface{} { return t.CardSuffix }},
+	{"category", func(t budget.Transaction) interface{} { return t.Category }},
+	{"debit", func(t budget.Transaction) interface{} { return dollars(t.DebitPennies) }},
+	{"credit", func(t budget.Transaction) interface{} { return dollars(t.CreditPennies) }},
+	{"amount", func(t budget.Transaction) interface{} { return dollars(amount(t)) }},
+	{"balance", func(t budget.Transaction) interface{} { return dollars(t.BalancePennies) }},
+	{"value_date", func(t budget.Transaction) interface{} { return date(t.ValueDate) }},
+	{"currency", func(t budget.Transaction) interface{} { return t.Currency }},
+	{"reference", func(t budget.Transaction) interface{} { return t.Reference }},
+	{"id", func(t budget.Transaction) interface{} { return TransactionID(t) }},
+}
+
+// date formats a date, or returns "" if it's zero, meaning unknown.
+func date(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format("2006-01-02")
+}
+
+// ParseColumns looks up a comma-separated list of column names, like
+// "date,description,amount". An empty list means every column.
+func ParseColumns(names string) ([]Column, error) {
+	if strings.TrimSpace(names) == "" {
+		return Columns, nil
+	}
+
+	var columns []Column
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		found := false
+		for _, column := range Columns {
+			if column.Name == name {
+				columns = append(columns, column)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %q; use any of %s", name, strings.Join(ColumnNames(), ","))
+		}
+	}
+
+	return columns, nil
+}
+
+// ColumnNames returns the names of every column.
+func ColumnNames() []string {
+	names := make([]string, len(Columns))
+	for i, column := range Columns {
+		names[i] = column.Name
+	}
+
+	return names
+}
+
+// WriteCSV writes the transactions as CSV, in date order, with a header.
+func WriteCSV(w io.Writer, transactions []budget.Transaction, columns []Column) error {
+	cw := csv.NewWriter(w)
+
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, transaction := range sorted(transactions) {
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			row[i] = fmt.Sprint(column.Value(transaction))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSONLines writes the transactions as JSON Lines, in date order:
+// one JSON object per line, keyed by column name.
+func WriteJSONLines(w io.Writer, transactions []budget.Transaction, columns []Column) error {
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+
+	for _, transaction := range sorted(transactions) {
+		if err := encoder.Encode(record(transaction, columns)); err != nil {
+			return err
+		}
+	}
+
+	return out.Flush()
+}
+
+// WriteJSON writes the transactions as a JSON array of objects, in date
+// order, keyed by column name.
+func WriteJSON(w io.Writer, transactions []budget.Transaction, columns []Column) error {
+	records := make([]orderedRecord, 0, len(transactions))
+	for _, transaction := range sorted(transactions) {
+		records = append(records, record(transaction, columns))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+// An orderedRecord is a transaction's selected columns, which it writes
+// as a JSON object with the keys in column order.
+type orderedRecord struct {
+	columns []Column
+	values  []interface{}
+}
+
+// record selects columns from a transaction.
+func record(transaction budget.Transaction, columns []Column) orderedRecord {
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		values[i] = column.Value(transaction)
+	}
+
+	return orderedRecord{columns: columns, values: values}
+}
+
+// MarshalJSON writes the record as an object, keeping the column order.
+func (r orderedRecord) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, column := range r.columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		key, _ := json.Marshal(column.Name)
+		value, err := json.Marshal(r.values[i])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+
+	return []byte(b.String()), nil
+}
+
+// dollars is an amount of money in pennies, which is written in dollars
+// with two decimal places, in both CSV and JSON.
+type dollars int64
+
+// String formats the amount, e.g. "-5.23".
+func (d dollars) String() string {
+	return decimal(int64(d))
+}
+
+// MarshalJSON writes the amount as a JSON number, e.g. -5.23.
+func (d dollars) MarshalJSON() ([]byte, error) {
+	return []byte(decimal(int64(d))), nil
+}
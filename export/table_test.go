@@ -0,0 +1,71 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Test choosing columns
+func TestParseColumns(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		if columns, err := ParseColumns(""); err != nil || len(columns) != len(Columns) {
+			t.Errorf("Expected every column, got %v, %v", columns, err)
+		}
+	})
+	t.Run("Some", func(t *testing.T) {
+		columns, err := ParseColumns("Date, amount")
+		if err != nil || len(columns) != 2 || columns[0].Name != "date" || columns[1].Name != "amount" {
+			t.Errorf("Expected date and amount, got %v, %v", columns, err)
+		}
+	})
+	t.Run("Unknown", func(t *testing.T) {
+		if _, err := ParseColumns("date,memo"); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
+
+// Test each tabular format
+func TestWriteTables(t *testing.T) {
+	columns, _ := ParseColumns("date,description,amount")
+
+	t.Run("CSV", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := WriteCSV(&b, exportFixture(), columns); err != nil {
+			t.Fatal(err)
+		}
+		want := "date,description,amount\n2018-01-02,Coffee  Shop,-5.23\n2018-01-03,Paycheck,1000.00\n"
+		if b.String() != want {
+			t.Errorf("Expected:\n%s\nGot:\n%s", want, b.String())
+		}
+	})
+	t.Run("JSONLines", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := WriteJSONLines(&b, exportFixture(), columns); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		if len(lines) != 2 || lines[0] != `{"date":"2018-01-02","description":"Coffee  Shop","amount":-5.23}` {
+			t.Errorf("Wrong JSON Lines:\n%s", b.String())
+		}
+	})
+	t.Run("JSON", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := WriteJSON(&b, exportFixture(), columns); err != nil {
+			t.Fatal(err)
+		}
+		var records []map[string]interface{}
+		if err := json.Unmarshal(b.Bytes(), &records); err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 2 || records[1]["amount"] != 1000.0 {
+			t.Errorf("Wrong JSON:\n%s", b.String())
+		}
+	})
+}
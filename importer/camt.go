@@ -0,0 +1,264 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/budney/budget/budget"
+)
+
+// camtDocument is the part of an ISO 20022 camt.053 bank-to-customer
+// statement that's needed. Element names match in any version of the
+// message, whatever its namespace.
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtStatement struct {
+	ID       string        `xml:"Id"`
+	IBAN     string        `xml:"Acct>Id>IBAN"`
+	Other    string        `xml:"Acct>Id>Othr>Id"`
+	Currency string        `xml:"Acct>Ccy"`
+	Balances []camtBalance `xml:"Bal"`
+	Entries  []camtEntry   `xml:"Ntry"`
+}
+
+type camtBalance struct {
+	Type      string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount    camtAmount `xml:"Amt"`
+	Indicator string     `xml:"CdtDbtInd"`
+}
+
+type camtAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type camtEntry struct {
+	EntryRef    string     `xml:"NtryRef"`
+	Amount      camtAmount `xml:"Amt"`
+	Indicator   string     `xml:"CdtDbtInd"`
+	Reversal    bool       `xml:"RvslInd"`
+	Status      camtStatus `xml:"Sts"`
+	BookingDate camtDate   `xml:"BookgDt"`
+	ValueDate   camtDate   `xml:"ValDt"`
+	ServicerRef string     `xml:"AcctSvcrRef"`
+	Proprietary string     `xml:"BkTxCd>Prtry>Cd"`
+	Family      string     `xml:"BkTxCd>Domn>Fmly>Cd"`
+	SubFamily   string     `xml:"BkTxCd>Domn>Fmly>SubFmlyCd"`
+	Info        string     `xml:"AddtlNtryInf"`
+	Details     []camtTx   `xml:"NtryDtls>TxDtls"`
+}
+
+// camtStatus is an entry's status, which is a bare code in older
+// versions of the message, and wrapped in a Cd element in newer ones.
+type camtStatus struct {
+	Text string `xml:",chardata"`
+	Code string `xml:"Cd"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+type camtTx struct {
+	EndToEndID string   `xml:"Refs>EndToEndId"`
+	Creditor   string   `xml:"RltdPties>Cdtr>Nm"`
+	Debtor     string   `xml:"RltdPties>Dbtr>Nm"`
+	Remittance []string `xml:"RmtInf>Ustrd"`
+}
+
+// ParseCAMT reads the booked entries of the statements in an ISO 20022
+// camt.053 file. Each transaction's account is its statement's IBAN, or
+// other account ID, or the default account if the statement has
+// neither; use the config file's worksheet mapping to send it to the
+// right worksheet.
+//
+// Transactions are dated by their booking date, and keep their value
+// date, the bank's reference, and their currency. Balances run from the
+// statement's opening balance, and must end at its closing balance.
+func ParseCAMT(r io.Reader, account string) ([]budget.Transaction, error) {
+	var document camtDocument
+	if err := xml.NewDecoder(r).Decode(&document); err != nil {
+		return nil, err
+	}
+
+	var transactions []budget.Transaction
+	for _, statement := range document.Statements {
+		parsed, err := statement.transactions(account)
+		if err != nil {
+			return transactions, fmt.Errorf("statement %s: %v", statement.ID, err)
+		}
+		transactions = append(transactions, parsed...)
+	}
+
+	return transactions, nil
+}
+
+// transactions converts a statement's booked entries, and checks them
+// against its balances.
+func (statement camtStatement) transactions(account string) ([]budget.Transaction, error) {
+	switch {
+	case statement.IBAN != "":
+		account = statement.IBAN
+	case statement.Other != "":
+		account = statement.Other
+	}
+
+	var transactions []budget.Transaction
+	for i, entry := range statement.Entries {
+		// Pending entries may still change
+		status := strings.TrimSpace(entry.Status.Code)
+		if status == "" {
+			status = strings.TrimSpace(entry.Status.Text)
+		}
+		if status != "" && status != "BOOK" {
+			continue
+		}
+
+		transaction, err := entry.transaction(account)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %v", i+1, err)
+		}
+		transaction.Index = i + 1
+		if transaction.Currency == "" {
+			transaction.Currency = statement.Currency
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	// Check the entries against the balances, if there are any
+	var opening, closing *camtBalance
+	for i := range statement.Balances {
+		switch statement.Balances[i].Type {
+		case "OPBD", "PRCD":
+			opening = &statement.Balances[i]
+		case "CLBD":
+			closing = &statement.Balances[i]
+		}
+	}
+	if opening != nil && closing != nil {
+		openingPennies, err := opening.pennies()
+		if err != nil {
+			return nil, err
+		}
+		closingPennies, err := closing.pennies()
+		if err != nil {
+			return nil, err
+		}
+		if err = runBalances(transactions, openingPennies, closingPennies); err != nil {
+			return nil, err
+		}
+	}
+
+	return transactions, nil
+}
+
+// transaction converts one entry.
+func (entry camtEntry) transaction(account string) (budget.Transaction, error) {
+	transaction := budget.Transaction{Account: account, Currency: entry.Amount.Currency}
+
+	var err error
+	if transaction.Date, err = entry.BookingDate.parse(); err != nil {
+		return transaction, fmt.Errorf("booking date: %v", err)
+	}
+	if entry.ValueDate.Date != "" || entry.ValueDate.DateTime != "" {
+		if transaction.ValueDate, err = entry.ValueDate.parse(); err != nil {
+			return transaction, fmt.Errorf("value date: %v", err)
+		}
+	}
+
+	pennies, err := parsePennies(entry.Amount.Value)
+	if err != nil {
+		return transaction, err
+	}
+	if entry.Indicator == "DBIT" {
+		pennies = -pennies
+	}
+	setAmount(&transaction, pennies)
+
+	// The most specific bank transaction code
+	switch {
+	case entry.Proprietary != "":
+		transaction.Type = entry.Proprietary
+	case entry.SubFamily != "":
+		transaction.Type = entry.SubFamily
+	case entry.Family != "":
+		transaction.Type = entry.Family
+	default:
+		transaction.Type = entry.Indicator
+	}
+
+	// The description is the other party and what the payment was for
+	var parts []string
+	var endToEnd string
+	for _, details := range entry.Details {
+		party := details.Creditor
+		if entry.Indicator == "CRDT" {
+			party = details.Debtor
+		}
+		parts = appendNonEmpty(parts, party)
+		parts = appendNonEmpty(parts, strings.Join(details.Remittance, " "))
+		if endToEnd == "" && details.EndToEndID != "NOTPROVIDED" {
+			endToEnd = details.EndToEndID
+		}
+	}
+	if len(parts) == 0 {
+		parts = appendNonEmpty(parts, entry.Info)
+	}
+	transaction.Description = strings.Join(strings.Fields(strings.Join(parts, " - ")), " ")
+
+	for _, reference := range []string{entry.ServicerRef, entry.EntryRef, endToEnd} {
+		if reference = strings.TrimSpace(reference); reference != "" {
+			transaction.Reference = reference
+			break
+		}
+	}
+
+	return transaction, nil
+}
+
+// parse reads a date, or the date of a date and time.
+func (date camtDate) parse() (time.Time, error) {
+	s := strings.TrimSpace(date.Date)
+	if s == "" {
+		s = strings.TrimSpace(date.DateTime)
+		if len(s) >= 10 {
+			s = s[:10]
+		}
+	}
+
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return t, fmt.Errorf("invalid date %q", s)
+	}
+
+	return dateOnly(t.Year(), t.Month(), t.Day()), nil
+}
+
+// pennies returns a balance in pennies, negative if it's a debit.
+func (balance camtBalance) pennies() (int64, error) {
+	pennies, err := parsePennies(balance.Amount.Value)
+	if balance.Indicator == "DBIT" {
+		pennies = -pennies
+	}
+
+	return pennies, err
+}
+
+// appendNonEmpty appends s to list, unless it's blank.
+func appendNonEmpty(list []string, s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return list
+	}
+
+	return append(list, strings.TrimSpace(s))
+}
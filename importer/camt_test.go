@@ -0,0 +1,61 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package importer
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test reading the booked entries of a camt.053 statement
+func TestParseCAMT(t *testing.T) {
+	f, err := os.Open("camt_test_01.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	transactions, err := ParseCAMT(f, "Default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 2 {
+		t.Fatalf("Expected 2 booked transactions, got %d: %+v", len(transactions), transactions)
+	}
+
+	t.Run("Debit", func(t *testing.T) {
+		power := transactions[0]
+		if !power.Date.Equal(time.Date(2018, time.January, 3, 0, 0, 0, 0, time.Local)) ||
+			!power.ValueDate.Equal(time.Date(2018, time.January, 2, 0, 0, 0, 0, time.Local)) {
+			t.Errorf("Wrong dates: %+v", power)
+		}
+		if power.DebitPennies != 4234 || power.Currency != "EUR" || power.Reference != "BANKREF-0001" ||
+			power.Type != "ESCT" || power.Description != "Stadtwerke Musterstadt - Strom Januar" {
+			t.Errorf("Wrong debit: %+v", power)
+		}
+		if power.Account != "DE89370400440532013000" || power.BalancePennies != 95766 {
+			t.Errorf("Wrong account or balance: %+v", power)
+		}
+	})
+	t.Run("Credit", func(t *testing.T) {
+		salary := transactions[1]
+		if salary.CreditPennies != 200000 || salary.Type != "SALARY" || salary.Reference != "PAYROLL-2018-01" ||
+			salary.Description != "Example GmbH - Gehalt Januar 2018" || salary.BalancePennies != 295766 {
+			t.Errorf("Wrong credit: %+v", salary)
+		}
+	})
+	t.Run("ClosingBalance", func(t *testing.T) {
+		f, err := os.ReadFile("camt_test_01.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrong := strings.Replace(string(f), "2957.66", "2957.67", 1)
+		if _, err := ParseCAMT(strings.NewReader(wrong), "Default"); err == nil {
+			t.Errorf("Expected an error when the closing balance doesn't match")
+		}
+	})
+}
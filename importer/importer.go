@@ -0,0 +1,120 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package importer reads transactions from files that banks and other
+// financial programs export, so they can be appended to the budget just
+// like transactions downloaded from the bank.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/budney/budget/budget"
+)
+
+// A Parser reads the transactions in a file. Transactions whose account
+// isn't named in the file get the default account.
+type Parser func(r io.Reader, account string) ([]budget.Transaction, error)
+
+// Parsers maps the name of each format to its parser.
+var Parsers = map[string]Parser{
+	"qif":   ParseQIF,
+	"camt":  ParseCAMT,
+	"mt940": ParseMT940,
+}
+
+// extensions maps file extensions to formats, where they differ.
+var extensions = map[string]string{
+	"xml": "camt",
+	"sta": "mt940",
+	"940": "mt940",
+}
+
+// FormatOf guesses a file's format from its extension. It returns the
+// extension itself if it isn't a known format.
+func FormatOf(fileName string) string {
+	extension := strings.ToLower(strings.TrimPrefix(filepath.Ext(fileName), "."))
+	if format, ok := extensions[extension]; ok {
+		return format
+	}
+
+	return extension
+}
+
+// Formats returns the names of the formats, sorted.
+func Formats() []string {
+	names := make([]string, 0, len(Parsers))
+	for name := range Parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// A LineError reports a line of a file that couldn't be parsed.
+type LineError struct {
+	Line int   // The line number, counting from 1
+	Err  error // What was wrong with it
+}
+
+// Error describes the line and what was wrong with it.
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// parsePennies reads an amount of money, in pennies. Amounts may have a
+// currency sign, thousands separators, and a leading or trailing sign.
+func parsePennies(s string) (int64, error) {
+	s = strings.NewReplacer("$", "", ",", "", " ", "").Replace(strings.TrimSpace(s))
+	if strings.HasSuffix(s, "-") {
+		s = "-" + strings.TrimSuffix(s, "-")
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	return int64(math.Round(v * 100)), nil
+}
+
+// runBalances sets the balance of each transaction, in order, starting
+// from the opening balance. It returns an error if they don't end at
+// the closing balance, which means transactions are missing.
+func runBalances(transactions []budget.Transaction, opening int64, closing int64) error {
+	balance := opening
+	for i := range transactions {
+		balance += transactions[i].CreditPennies - transactions[i].DebitPennies
+		transactions[i].BalancePennies = balance
+	}
+
+	if balance != closing {
+		return fmt.Errorf("transactions add up to a balance of %.2f, but the closing balance is %.2f", float64(balance)/100, float64(closing)/100)
+	}
+	return nil
+}
+
+// setAmount sets a transaction's debit or credit from a signed amount,
+// where negative amounts are debits.
+func setAmount(transaction *budget.Transaction, pennies int64) {
+	transaction.DebitPennies, transaction.CreditPennies = 0, 0
+	if pennies < 0 {
+		transaction.DebitPennies = -pennies
+	} else {
+		transaction.CreditPennies = pennies
+	}
+}
+
+// dateOnly returns a date with no time of day, in local time.
+func dateOnly(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
@@ -0,0 +1,284 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/budney/budget/budget"
+)
+
+// mt940Field is one ":tag:" field of an MT940 statement, with any
+// continuation lines joined to it.
+type mt940Field struct {
+	line  int // Where the field started
+	tag   string
+	value string
+}
+
+// mt940Tag matches the tag that starts a field.
+var mt940Tag = regexp.MustCompile(`^:(\d\d[A-Z]?):(.*)$`)
+
+// mt940Balance matches an opening or closing balance: credit or debit,
+// YYMMDD, currency, and an amount with a decimal comma.
+var mt940Balance = regexp.MustCompile(`^([CD])(\d{6})([A-Z]{3})([\d,]+)$`)
+
+// mt940Line matches a statement line: value date YYMMDD, optional entry
+// date MMDD, credit or debit (or their reversals), optional funds code,
+// amount, transaction type, customer reference, and optional bank
+// reference after "//".
+var mt940Line = regexp.MustCompile(`^(\d{6})(\d{4})?(RC|RD|C|D)([A-Z])?([\d,]+)([NFS][A-Z0-9]{3})(.*?)(?://(.*?))?(?:\n([\s\S]*))?$`)
+
+// mt940Subfield matches the "?NN" subfields that many banks use in the
+// information field.
+var mt940Subfield = regexp.MustCompile(`\?(\d\d)`)
+
+// ParseMT940 reads the statements in a SWIFT MT940 file. Each
+// transaction's account is its statement's account, from the :25: field,
+// or the default account if the statement doesn't have one; use the
+// config file's worksheet mapping to send it to the right worksheet.
+//
+// Transactions are dated by their entry date, if given, and keep their
+// value date, the bank's reference, and the statement's currency.
+// Balances run from the statement's opening balance, and must end at its
+// closing balance; a statement without an opening balance isn't checked.
+// Each statement's transactions are indexed from 1.
+func ParseMT940(r io.Reader, account string) ([]budget.Transaction, error) {
+	fields, err := mt940Fields(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var transactions []budget.Transaction
+	var statement []budget.Transaction
+	current := account
+	currency := ""
+	var opening int64
+	opened := false
+	index := 0
+
+	for _, field := range fields {
+		switch field.tag {
+		case "20":
+			// A new statement; keep any unchecked lines of the last one
+			transactions = append(transactions, statement...)
+			current, currency, statement = account, "", nil
+			opening, opened, index = 0, false, 0
+		case "25":
+			if value := strings.TrimSpace(field.value); value != "" {
+				current = value
+			}
+		case "60F", "60M":
+			var pennies int64
+			if pennies, currency, err = parseMT940Balance(field.value); err != nil {
+				return transactions, LineError{Line: field.line, Err: err}
+			}
+			opening, opened = pennies, true
+			statement = nil
+		case "61":
+			transaction, err := parseMT940Line(field.value)
+			if err != nil {
+				return transactions, LineError{Line: field.line, Err: err}
+			}
+			index++
+			transaction.Index = index
+			transaction.Account = current
+			transaction.Currency = currency
+			statement = append(statement, transaction)
+		case "86":
+			// The information field describes the statement line before it
+			if len(statement) > 0 {
+				describeMT940(&statement[len(statement)-1], field.value)
+			}
+		case "62F", "62M":
+			closing, _, err := parseMT940Balance(field.value)
+			if err != nil {
+				return transactions, LineError{Line: field.line, Err: err}
+			}
+			if opened {
+				if err = runBalances(statement, opening, closing); err != nil {
+					return transactions, LineError{Line: field.line, Err: err}
+				}
+			}
+			transactions = append(transactions, statement...)
+			statement = nil
+		}
+	}
+
+	// A statement without a closing balance can't be checked
+	transactions = append(transactions, statement...)
+
+	return transactions, nil
+}
+
+// mt940Fields splits a file into fields, skipping any SWIFT message
+// envelope and the "-" that ends each message.
+func mt940Fields(r io.Reader) ([]mt940Field, error) {
+	var fields []mt940Field
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r\n\t ")
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+
+		// The envelope's text block runs from "{4:" to "-}"
+		if i := strings.Index(text, "{4:"); i >= 0 {
+			text = text[i+3:]
+		}
+		if text == "" || text == "-" || text == "-}" || strings.HasPrefix(text, "{") {
+			continue
+		}
+
+		if match := mt940Tag.FindStringSubmatch(text); match != nil {
+			fields = append(fields, mt940Field{line: line, tag: match[1], value: match[2]})
+			continue
+		}
+		if len(fields) == 0 {
+			return nil, LineError{Line: line, Err: fmt.Errorf("expected a field, got %q", text)}
+		}
+
+		last := &fields[len(fields)-1]
+		last.value += "\n" + text
+	}
+
+	return fields, scanner.Err()
+}
+
+// parseMT940Balance reads a balance field, returning the balance in
+// pennies, negative if it's a debit, and its currency.
+func parseMT940Balance(value string) (int64, string, error) {
+	match := mt940Balance.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return 0, "", fmt.Errorf("invalid balance %q", value)
+	}
+
+	pennies, err := parseMT940Amount(match[4])
+	if match[1] == "D" {
+		pennies = -pennies
+	}
+
+	return pennies, match[3], err
+}
+
+// parseMT940Line reads a :61: statement line.
+func parseMT940Line(value string) (budget.Transaction, error) {
+	var transaction budget.Transaction
+
+	match := mt940Line.FindStringSubmatch(value)
+	if match == nil {
+		return transaction, fmt.Errorf("invalid statement line %q", value)
+	}
+
+	valueDate, err := parseMT940Date(match[1])
+	if err != nil {
+		return transaction, err
+	}
+	transaction.ValueDate = valueDate
+	transaction.Date = valueDate
+
+	// The entry date has no year, so it's the one nearest the value date
+	if match[2] != "" {
+		month, _ := strconv.Atoi(match[2][:2])
+		day, _ := strconv.Atoi(match[2][2:])
+		entry := dateOnly(valueDate.Year(), time.Month(month), day)
+		switch {
+		case entry.Sub(valueDate) > 180*24*time.Hour:
+			entry = entry.AddDate(-1, 0, 0)
+		case valueDate.Sub(entry) > 180*24*time.Hour:
+			entry = entry.AddDate(1, 0, 0)
+		}
+		transaction.Date = entry
+	}
+
+	pennies, err := parseMT940Amount(match[5])
+	if err != nil {
+		return transaction, err
+	}
+	// A reversed credit takes money out, and a reversed debit puts it back
+	if match[3] == "D" || match[3] == "RC" {
+		pennies = -pennies
+	}
+	setAmount(&transaction, pennies)
+
+	transaction.Type = match[6]
+	customer := strings.TrimSpace(match[7])
+	bank := strings.TrimSpace(match[8])
+	switch {
+	case bank != "":
+		transaction.Reference = bank
+	case customer != "" && customer != "NONREF":
+		transaction.Reference = customer
+	}
+
+	// Supplementary details are the description, until :86: replaces it
+	transaction.Description = strings.TrimSpace(match[9])
+
+	return transaction, nil
+}
+
+// describeMT940 sets a transaction's description from an :86:
+// information field. Fields with "?NN" subfields take the name from
+// ?32 and ?33, and the purpose from ?20 to ?29; others are used as is.
+func describeMT940(transaction *budget.Transaction, value string) {
+	value = strings.ReplaceAll(value, "\n", "")
+
+	if !mt940Subfield.MatchString(value) {
+		if description := strings.Join(strings.Fields(value), " "); description != "" {
+			transaction.Description = description
+		}
+		return
+	}
+
+	var name, purpose []string
+	locations := mt940Subfield.FindAllStringSubmatchIndex(value, -1)
+	for i, location := range locations {
+		end := len(value)
+		if i+1 < len(locations) {
+			end = locations[i+1][0]
+		}
+		code, _ := strconv.Atoi(value[location[2]:location[3]])
+		text := value[location[1]:end]
+
+		switch {
+		case code >= 20 && code <= 29, code >= 60 && code <= 63:
+			purpose = append(purpose, text)
+		case code == 32 || code == 33:
+			name = append(name, text)
+		}
+	}
+
+	parts := appendNonEmpty(nil, strings.Join(name, ""))
+	parts = appendNonEmpty(parts, strings.Join(purpose, ""))
+	if len(parts) > 0 {
+		transaction.Description = strings.Join(strings.Fields(strings.Join(parts, " - ")), " ")
+	}
+}
+
+// parseMT940Date reads a YYMMDD date. Years before 70 are in the 2000s.
+func parseMT940Date(s string) (time.Time, error) {
+	t, err := time.Parse("060102", s)
+	if err != nil {
+		return t, fmt.Errorf("invalid date %q", s)
+	}
+
+	return dateOnly(t.Year(), t.Month(), t.Day()), nil
+}
+
+// parseMT940Amount reads an amount with a decimal comma, in pennies.
+func parseMT940Amount(s string) (int64, error) {
+	if strings.HasSuffix(s, ",") {
+		s += "0"
+	}
+
+	return parsePennies(strings.Replace(s, ",", ".", 1))
+}
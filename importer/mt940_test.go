@@ -0,0 +1,77 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package importer
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test reading the statement lines of an MT940 statement
+func TestParseMT940(t *testing.T) {
+	f, err := os.Open("mt940_test_01.sta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	transactions, err := ParseMT940(f, "Default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 3 {
+		t.Fatalf("Expected 3 transactions, got %d: %+v", len(transactions), transactions)
+	}
+
+	t.Run("Subfields", func(t *testing.T) {
+		power := transactions[0]
+		if !power.Date.Equal(time.Date(2018, time.January, 3, 0, 0, 0, 0, time.Local)) ||
+			!power.ValueDate.Equal(time.Date(2018, time.January, 2, 0, 0, 0, 0, time.Local)) {
+			t.Errorf("Wrong dates: %+v", power)
+		}
+		if power.DebitPennies != 4234 || power.Currency != "EUR" || power.Reference != "BANKREF-0001" ||
+			power.Type != "NTRF" || power.Description != "Stadtwerke Musterstadt - Strom Januar" {
+			t.Errorf("Wrong debit: %+v", power)
+		}
+		if power.Account != "10020030/1234567890" || power.BalancePennies != 95766 {
+			t.Errorf("Wrong account or balance: %+v", power)
+		}
+	})
+	t.Run("SupplementaryDetails", func(t *testing.T) {
+		salary := transactions[1]
+		if salary.CreditPennies != 200000 || salary.Reference != "PAYROLL-2018-01" || salary.Description != "Salary January" {
+			t.Errorf("Wrong credit: %+v", salary)
+		}
+		if salary.Date.Year() != 2018 || salary.Date.Month() != time.December {
+			t.Errorf("Wrong entry date: %v", salary.Date)
+		}
+	})
+	t.Run("Reversal", func(t *testing.T) {
+		reversal := transactions[2]
+		if reversal.DebitPennies != 1000 || reversal.Reference != "" || reversal.Description != "Reversal of interest credited in error" ||
+			reversal.BalancePennies != 294766 {
+			t.Errorf("Wrong reversal: %+v", reversal)
+		}
+	})
+	t.Run("ClosingBalance", func(t *testing.T) {
+		wrong := ":20:X\n:60F:C180101EUR1,00\n:61:180102D1,00NTRFNONREF\n:62F:C180102EUR1,00\n"
+		if _, err := ParseMT940(strings.NewReader(wrong), "Default"); err == nil {
+			t.Errorf("Expected an error when the closing balance doesn't match")
+		}
+	})
+	t.Run("NextStatement", func(t *testing.T) {
+		statements := ":20:A\n:60F:C180101EUR10,00\n:61:180102D1,00NTRFNONREF\n:62F:C180102EUR9,00\n" +
+			":20:B\n:61:180103D2,00NTRFNONREF\n:62F:C180103EUR7,00\n"
+		transactions, err := ParseMT940(strings.NewReader(statements), "Default")
+		if err != nil || len(transactions) != 2 {
+			t.Fatalf("Expected 2 transactions, got %+v, %v", transactions, err)
+		}
+		if second := transactions[1]; second.Index != 1 || second.BalancePennies != 0 {
+			t.Errorf("Expected the second statement to start afresh: %+v", second)
+		}
+	})
+}
@@ -0,0 +1,324 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/budney/budget/budget"
+)
+
+// qifSections lists the QIF sections that hold bank-style transactions.
+// Others, such as investments or category lists, are skipped.
+var qifSections = map[string]bool{
+	"bank":  true,
+	"ccard": true,
+	"cash":  true,
+	"oth a": true,
+	"oth l": true,
+}
+
+// qifRecord holds the fields of one QIF record, up to its "^".
+type qifRecord struct {
+	line     int // Where the record started
+	date     string
+	amount   string
+	number   string
+	payee    string
+	memo     string
+	category string
+	splits   []qifSplit
+}
+
+// qifSplit is one split of a transaction, from its S, E and $ lines.
+type qifSplit struct {
+	category string
+	memo     string
+	amount   string
+}
+
+// ParseQIF reads the bank, credit card, cash and other asset and
+// liability transactions in a QIF file. Transactions in an !Account
+// section get that account's name; the rest get the default account.
+//
+// A split transaction becomes one transaction per split, each with the
+// split's category and amount; the splits must add up to the
+// transaction's total. Records in other sections are skipped. A last
+// record with no "^" after it is read anyway.
+//
+// Categories come from L and S lines, with any "/class" suffix removed;
+// transfers, like "[Savings]", get the category "Transfer:Savings". QIF
+// files don't have balances, so the transactions' balances are zero.
+func ParseQIF(r io.Reader, account string) ([]budget.Transaction, error) {
+	var transactions []budget.Transaction
+
+	scanner := bufio.NewScanner(r)
+	section := ""
+	current := account
+	record := qifRecord{}
+	var accountName string
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r\n\t ")
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if text == "" {
+			continue
+		}
+
+		// Headers start a section
+		if text[0] == '!' {
+			header := strings.ToLower(strings.TrimSpace(text[1:]))
+			switch {
+			case header == "account":
+				section = "account"
+			case strings.HasPrefix(header, "type:"):
+				section = strings.TrimSpace(strings.TrimPrefix(header, "type:"))
+			case strings.HasPrefix(header, "option:"), strings.HasPrefix(header, "clear:"):
+				// Options don't change the section
+			default:
+				section = header
+			}
+			record = qifRecord{}
+			continue
+		}
+
+		code, value := text[0], strings.TrimSpace(text[1:])
+		if code == '^' {
+			switch {
+			case section == "account":
+				if accountName != "" {
+					current = accountName
+				}
+				accountName = ""
+			case qifSections[section]:
+				parsed, err := record.transactions(current, len(transactions))
+				if err != nil {
+					return transactions, LineError{Line: record.line, Err: err}
+				}
+				transactions = append(transactions, parsed...)
+			}
+			record = qifRecord{}
+			continue
+		}
+
+		if section == "account" {
+			if code == 'N' {
+				accountName = value
+			}
+			continue
+		}
+
+		// Other sections' records, like categories or investments,
+		// have fields with different meanings
+		if !qifSections[section] {
+			continue
+		}
+
+		if record.line == 0 {
+			record.line = line
+		}
+		switch code {
+		case 'D':
+			record.date = value
+		case 'T', 'U':
+			record.amount = value
+		case 'N':
+			record.number = value
+		case 'P':
+			record.payee = value
+		case 'M':
+			record.memo = value
+		case 'L':
+			record.category = value
+		case 'S':
+			record.splits = append(record.splits, qifSplit{category: value})
+		case 'E', '$':
+			if len(record.splits) == 0 {
+				return transactions, LineError{Line: line, Err: fmt.Errorf("split line %q before any S line", text)}
+			}
+			split := &record.splits[len(record.splits)-1]
+			if code == 'E' {
+				split.memo = value
+			} else {
+				split.amount = value
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return transactions, err
+	}
+
+	// Some programs leave out the last record's "^"
+	if record.line != 0 && qifSections[section] {
+		parsed, err := record.transactions(current, len(transactions))
+		if err != nil {
+			return transactions, LineError{Line: record.line, Err: err}
+		}
+		transactions = append(transactions, parsed...)
+	}
+
+	return transactions, nil
+}
+
+// transactions converts a record into one transaction, or one per split.
+// Transactions are numbered from after, so they keep the file's order.
+func (record qifRecord) transactions(account string, after int) ([]budget.Transaction, error) {
+	date, err := parseQIFDate(record.date)
+	if err != nil {
+		return nil, err
+	}
+
+	base := budget.Transaction{
+		Date:        date,
+		Type:        qifType(record.number),
+		Description: record.payee,
+		Account:     account,
+		Category:    qifCategory(record.category),
+	}
+	if base.Description == "" {
+		base.Description = record.memo
+	}
+
+	if len(record.splits) == 0 {
+		pennies, err := parsePennies(record.amount)
+		if err != nil {
+			return nil, err
+		}
+
+		base.Index = after + 1
+		setAmount(&base, pennies)
+		return []budget.Transaction{base}, nil
+	}
+
+	var transactions []budget.Transaction
+	var total int64
+	for i, split := range record.splits {
+		pennies, err := parsePennies(split.amount)
+		if err != nil {
+			return nil, fmt.Errorf("split %d: %v", i+1, err)
+		}
+		total += pennies
+
+		transaction := base
+		transaction.Index = after + i + 1
+		transaction.Category = qifCategory(split.category)
+		if split.memo != "" {
+			transaction.Description = strings.TrimSpace(record.payee + " - " + split.memo)
+			transaction.Description = strings.TrimPrefix(transaction.Description, "- ")
+		}
+		setAmount(&transaction, pennies)
+		transactions = append(transactions, transaction)
+	}
+
+	// The splits must add up to the transaction's total, if it has one
+	if record.amount != "" {
+		pennies, err := parsePennies(record.amount)
+		if err != nil {
+			return nil, err
+		}
+		if pennies != total {
+			return nil, fmt.Errorf("splits add up to %.2f, but the total is %.2f", float64(total)/100, float64(pennies)/100)
+		}
+	}
+
+	return transactions, nil
+}
+
+// qifType converts a QIF number field into a transaction type. Check
+// numbers become "Check #123"; words like "ATM" or "DEP" are kept.
+func qifType(number string) string {
+	if number == "" {
+		return ""
+	}
+	if _, err := strconv.Atoi(number); err == nil {
+		return "Check #" + number
+	}
+
+	return number
+}
+
+// qifCategory converts a QIF category, removing any class after a
+// slash, and naming transfers between accounts.
+func qifCategory(category string) string {
+	if i := strings.Index(category, "/"); i >= 0 {
+		category = category[:i]
+	}
+	category = strings.TrimSpace(category)
+
+	if strings.HasPrefix(category, "[") && strings.HasSuffix(category, "]") {
+		return "Transfer:" + strings.TrimSpace(category[1:len(category)-1])
+	}
+
+	return category
+}
+
+// qifDate matches the numeric dates found in QIF files, like "1/2/2018",
+// "01/02/18", "1/ 2'18", "1-2-18" or "02.01.2018".
+var qifDate = regexp.MustCompile(`^(\d{1,4})\s*([/.\-])\s*(\d{1,2})\s*(?:[/.\-]|('))\s*(\d{2,4})$`)
+
+// parseQIFDate reads a date in any of the styles QIF files use. Quicken
+// writes years after 1999 with an apostrophe, as in "1/2'18". Dates with
+// dots are day first, as in Europe; dates with slashes or dashes are
+// month first, unless the first number can't be a month. Years can come
+// first, as in "2018-01-02". Anything else is handed to dateparse.
+func parseQIFDate(s string) (time.Time, error) {
+	m := qifDate.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		t, err := dateparse.ParseLocal(s)
+		if err != nil {
+			return t, fmt.Errorf("invalid date %q", s)
+		}
+		return dateOnly(t.Year(), t.Month(), t.Day()), nil
+	}
+
+	a, _ := strconv.Atoi(m[1])
+	b, _ := strconv.Atoi(m[3])
+	year, _ := strconv.Atoi(m[5])
+
+	var month, day int
+	switch {
+	case len(m[1]) == 4:
+		// Year first: the last number is the day
+		year, month, day = a, b, year
+	case m[2] == ".":
+		day, month = a, b
+	case a > 12:
+		day, month = a, b
+	default:
+		month, day = a, b
+	}
+
+	// Two-digit years
+	if year < 100 {
+		switch {
+		case m[4] == "'":
+			year += 2000
+		case year < 70:
+			year += 2000
+		default:
+			year += 1900
+		}
+	}
+
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+
+	date := dateOnly(year, time.Month(month), day)
+	if date.Day() != day {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+
+	return date, nil
+}
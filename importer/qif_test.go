@@ -0,0 +1,148 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package importer
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test reading every kind of record in a QIF file
+func TestParseQIF(t *testing.T) {
+	f, err := os.Open("qif_test_01.qif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	transactions, err := ParseQIF(f, "Default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 5 {
+		t.Fatalf("Expected 5 transactions, got %d: %+v", len(transactions), transactions)
+	}
+
+	t.Run("Check", func(t *testing.T) {
+		rent := transactions[0]
+		if !rent.Date.Equal(time.Date(2018, time.January, 2, 0, 0, 0, 0, time.Local)) || rent.DebitPennies != 123456 ||
+			rent.Type != "Check #1001" || rent.Category != "Rent" || rent.Account != "Credit Union Checking" {
+			t.Errorf("Wrong check: %+v", rent)
+		}
+	})
+	t.Run("Splits", func(t *testing.T) {
+		food, household := transactions[1], transactions[2]
+		if food.Category != "Food:Groceries" || food.DebitPennies != 6000 || food.Description != "Grocery Mart - Produce" {
+			t.Errorf("Wrong first split: %+v", food)
+		}
+		if household.Category != "Household" || household.DebitPennies != 4000 || household.Index != food.Index+1 {
+			t.Errorf("Wrong second split: %+v", household)
+		}
+	})
+	t.Run("Transfer", func(t *testing.T) {
+		deposit := transactions[3]
+		if deposit.CreditPennies != 50000 || deposit.Category != "Transfer:Savings" || deposit.Type != "DEP" {
+			t.Errorf("Wrong deposit: %+v", deposit)
+		}
+	})
+	t.Run("CreditCard", func(t *testing.T) {
+		coffee := transactions[4]
+		if coffee.Account != "Visa" || coffee.Date.Month() != time.January || coffee.Date.Day() != 15 {
+			t.Errorf("Wrong credit card transaction: %+v", coffee)
+		}
+	})
+	t.Run("DefaultAccount", func(t *testing.T) {
+		transactions, err := ParseQIF(strings.NewReader("!Type:Bank\nD1/2/18\nT-1\n^\n"), "Default")
+		if err != nil || len(transactions) != 1 || transactions[0].Account != "Default" {
+			t.Errorf("Expected the default account, got %+v, %v", transactions, err)
+		}
+	})
+	t.Run("BadAmount", func(t *testing.T) {
+		_, err := ParseQIF(strings.NewReader("!Type:Bank\nD1/2/18\nTlots\n^\n"), "Default")
+		if lineErr, ok := err.(LineError); !ok || lineErr.Line != 2 {
+			t.Errorf("Expected an error on line 2, got %v", err)
+		}
+	})
+	t.Run("NoLastCaret", func(t *testing.T) {
+		qif := "!Type:Bank\nD1/2/18\nT-5.00\nPCoffee\n^\nD1/3/18\nT-12.00\nPLunch\n"
+		transactions, err := ParseQIF(strings.NewReader(qif), "Default")
+		if err != nil || len(transactions) != 2 || transactions[1].Description != "Lunch" {
+			t.Errorf("Expected 2 transactions, got %+v, %v", transactions, err)
+		}
+	})
+	t.Run("BadLastRecord", func(t *testing.T) {
+		qif := "!Type:Bank\nD1/2/18\nT-5.00\n^\nDsomeday\nT-12.00\n"
+		_, err := ParseQIF(strings.NewReader(qif), "Default")
+		if lineErr, ok := err.(LineError); !ok || lineErr.Line != 5 {
+			t.Errorf("Expected an error on line 5, got %v", err)
+		}
+	})
+}
+
+// Test the date styles QIF files use
+func TestParseQIFDate(t *testing.T) {
+	want := time.Date(2018, time.January, 2, 0, 0, 0, 0, time.Local)
+	for _, s := range []string{"1/2/2018", "01/02/18", "1/2'18", "1/ 2'18", " 1/ 2/18", "1-2-18", "2018-01-02", "02.01.2018", "Jan 2, 2018"} {
+		if got, err := parseQIFDate(s); err != nil || !got.Equal(want) {
+			t.Errorf("%q: expected %s, got %s, %v", s, want, got, err)
+		}
+	}
+
+	if got, err := parseQIFDate("13/1/2018"); err != nil || got.Month() != time.January || got.Day() != 13 {
+		t.Errorf("Expected January 13, got %s, %v", got, err)
+	}
+	if _, err := parseQIFDate("2/30/2018"); err == nil {
+		t.Error("Expected an error for February 30")
+	}
+}
+
+// Test that records in other sections, whose fields mean other things,
+// are skipped
+func TestParseQIFMixedSections(t *testing.T) {
+	f, err := os.Open("qif_test_02.qif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	transactions, err := ParseQIF(f, "Default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 3 {
+		t.Fatalf("Expected 3 transactions, got %d: %+v", len(transactions), transactions)
+	}
+
+	if repairs := transactions[0]; repairs.Category != "Home:Repairs" || repairs.DebitPennies != 5000 {
+		t.Errorf("Wrong first split: %+v", repairs)
+	}
+	if appraisal := transactions[2]; appraisal.Category != "Home Value" || appraisal.CreditPennies != 100000 {
+		t.Errorf("Wrong asset transaction: %+v", appraisal)
+	}
+
+	t.Run("SplitTotal", func(t *testing.T) {
+		qif := "!Type:Bank\nD1/2/18\nT-100.00\nSFood\n$-60.00\nSHousehold\n$-30.00\n^\n"
+		_, err := ParseQIF(strings.NewReader(qif), "Default")
+		if lineErr, ok := err.(LineError); !ok || lineErr.Line != 2 {
+			t.Errorf("Expected an error on line 2, got %v", err)
+		}
+	})
+	t.Run("NoLastCaret", func(t *testing.T) {
+		qif := "!Type:Bank\nD1/2/18\nT-5.00\nPCoffee\n^\nD1/3/18\nT-12.00\nPLunch\n"
+		transactions, err := ParseQIF(strings.NewReader(qif), "Default")
+		if err != nil || len(transactions) != 2 || transactions[1].Description != "Lunch" {
+			t.Errorf("Expected 2 transactions, got %+v, %v", transactions, err)
+		}
+	})
+	t.Run("BadLastRecord", func(t *testing.T) {
+		qif := "!Type:Bank\nD1/2/18\nT-5.00\n^\nDsomeday\nT-12.00\n"
+		_, err := ParseQIF(strings.NewReader(qif), "Default")
+		if lineErr, ok := err.(LineError); !ok || lineErr.Line != 5 {
+			t.Errorf("Expected an error on line 5, got %v", err)
+		}
+	})
+}
@@ -10,8 +10,10 @@
 package index
 
 import (
+	"context"
 	"fmt"
 	"github.com/araddon/dateparse"
+	"github.com/budney/budget/retry"
 	"google.golang.org/api/sheets/v4"
 	"log"
 	"time"
@@ -48,20 +50,13 @@ func getDate(t time.Time) time.Time {
 // start and end values, and whether the record's last updated date was
 // prior to the record's end date.
 func getActiveRecordTester(start time.Time, end time.Time) func(Record) bool {
-	start = getDate(start)
-	end = getDate(end).Add(24*time.Hour - 1*time.Second) // Not leap-second proof
+	overlaps := getOverlapTester(start, end)
 
 	return func(record Record) bool {
-		a := getDate(record.Start)
 		b := getDate(record.End).Add(24 * time.Hour)
 
-		// This record ends before the time interval starts
-		if start.After(b) {
-			return false
-		}
-
-		// This record starts after the time interval ends
-		if end.Before(a) {
+		// This record doesn't overlap the time interval
+		if !overlaps(record) {
 			return false
 		}
 
@@ -80,6 +75,31 @@ func getActiveRecordTester(start time.Time, end time.Time) func(Record) bool {
 	}
 }
 
+// getOverlapTester returns a closure that tests whether a record's
+// start/end dates overlap with the specified start and end values,
+// regardless of when the record was last updated.
+func getOverlapTester(start time.Time, end time.Time) func(Record) bool {
+	start = getDate(start)
+	end = getDate(end).Add(24*time.Hour - 1*time.Second) // Not leap-second proof
+
+	return func(record Record) bool {
+		a := getDate(record.Start)
+		b := getDate(record.End).Add(24 * time.Hour)
+
+		// This record ends before the time interval starts
+		if start.After(b) {
+			return false
+		}
+
+		// This record starts after the time interval ends
+		if end.Before(a) {
+			return false
+		}
+
+		return true
+	}
+}
+
 // Filter accepts an array of Records and a "test" function that
 // accepts a Record and returns a boolean. It returns an array
 // of those records for which the test function returned true.
@@ -100,13 +120,78 @@ func FilterActiveRecords(history []Record, start time.Time, end time.Time) []Rec
 	return Filter(history, test)
 }
 
+// FilterOverlapping accepts an array of Records and a start and end date.
+// It returns the records whose dates overlap the range, including ones
+// updated after they ended, for reading transactions back out of them.
+func FilterOverlapping(history []Record, start time.Time, end time.Time) []Record {
+	return Filter(history, getOverlapTester(start, end))
+}
+
+// Covering returns the record whose dates include the specified day,
+// which is where transactions from that day belong. If more than one
+// does, it returns the last of them.
+func Covering(history []Record, day time.Time) (Record, bool) {
+	day = getDate(day)
+	for i := len(history) - 1; i >= 0; i-- {
+		record := history[i]
+		if !day.Before(getDate(record.Start)) && !day.After(getDate(record.End)) {
+			return record, true
+		}
+	}
+
+	return Record{}, false
+}
+
+// A Period is a range of dates, inclusive.
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Gaps accepts an array of Records and a start and end date. It returns
+// the periods between start and end that aren't covered by any of the
+// records, in order. If the records cover the whole range, it returns
+// an empty array.
+func Gaps(history []Record, start time.Time, end time.Time) (gaps []Period) {
+	covered := func(day time.Time) bool {
+		for _, record := range history {
+			if !day.Before(getDate(record.Start)) && !day.After(getDate(record.End)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	end = getDate(end)
+	for day := getDate(start); !day.After(end); day = day.AddDate(0, 0, 1) {
+		if covered(day) {
+			continue
+		}
+
+		// Extend the last gap if it ended yesterday, else start a new one
+		if n := len(gaps); n > 0 && gaps[n-1].End.AddDate(0, 0, 1).Equal(day) {
+			gaps[n-1].End = day
+		} else {
+			gaps = append(gaps, Period{Start: day, End: day})
+		}
+	}
+
+	return gaps
+}
+
 // FromGoogleSheet uses the Google sheets service and specified spreadsheet ID
 // to read all the index Records on that sheet, which it returns as an array.
-func FromGoogleSheet(srv *sheets.Service, spreadsheetID string) ([]Record, error) {
+// Failed reads are retried according to the policy, and the read stops
+// if ctx is cancelled.
+func FromGoogleSheet(ctx context.Context, srv *sheets.Service, spreadsheetID string, policy retry.Policy) ([]Record, error) {
 	var history []Record
+	var response *sheets.ValueRange
 
 	// Open the spreadsheet
-	response, err := srv.Spreadsheets.Values.Get(spreadsheetID, Range).Do()
+	err := policy.Do(ctx, "Read index", func() (err error) {
+		response, err = srv.Spreadsheets.Values.Get(spreadsheetID, Range).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		log.Printf("Unable to retrieve index from sheet ID %s: %v", spreadsheetID, err)
 		return history, err
@@ -169,3 +254,26 @@ func FromSpreadsheetRow(index int, row []interface{}) (Record, error) {
 
 	return record, nil
 }
+
+// SetLastUpdated writes the LastUpdated date of a record to the index
+// spreadsheet. A zero time clears it.
+func SetLastUpdated(ctx context.Context, srv *sheets.Service, record Record, lastUpdated time.Time, policy retry.Policy) error {
+	// Records are numbered from the first row of Range, which is row 2
+	cell := fmt.Sprintf("Index!D%d", record.Index+1)
+
+	value := ""
+	if !lastUpdated.IsZero() {
+		value = lastUpdated.Format("2006-01-02 15:04:05")
+	}
+	valueRange := &sheets.ValueRange{Range: cell, Values: [][]interface{}{{value}}}
+
+	err := policy.Do(ctx, "Update "+cell, func() error {
+		_, err := srv.Spreadsheets.Values.Update(record.IndexID, cell, valueRange).ValueInputOption("USER_ENTERED").Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		log.Printf("Unable to update %s in index sheet ID %s: %v", cell, record.IndexID, err)
+	}
+
+	return err
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"testing"
+	"time"
+)
+
+// Test finding the dates that no record covers
+func TestGaps(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2018, month, d, 0, 0, 0, 0, time.Local)
+	}
+	history := []Record{
+		{Start: day(time.January, 1), End: day(time.January, 31)},
+		{Start: day(time.March, 1), End: day(time.March, 31)},
+	}
+
+	t.Run("Covered", func(t *testing.T) {
+		if gaps := Gaps(history, day(time.January, 5), day(time.January, 31)); len(gaps) != 0 {
+			t.Errorf("Expected no gaps, got %v", gaps)
+		}
+	})
+	t.Run("Middle", func(t *testing.T) {
+		gaps := Gaps(history, day(time.January, 15), day(time.March, 15))
+		if len(gaps) != 1 || !gaps[0].Start.Equal(day(time.February, 1)) || !gaps[0].End.Equal(day(time.February, 28)) {
+			t.Errorf("Expected February, got %v", gaps)
+		}
+	})
+	t.Run("BothEnds", func(t *testing.T) {
+		if gaps := Gaps(history, day(time.February, 27), day(time.April, 2)); len(gaps) != 2 {
+			t.Errorf("Expected 2 gaps, got %v", gaps)
+		}
+	})
+}
+
+// Test finding the record a day belongs to
+func TestCovering(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2018, month, d, 12, 0, 0, 0, time.Local)
+	}
+	history := []Record{
+		{Index: 1, Start: day(time.January, 1), End: day(time.January, 31)},
+		{Index: 2, Start: day(time.January, 31), End: day(time.February, 28)},
+	}
+
+	for _, test := range []struct {
+		day   time.Time
+		index int
+	}{
+		{day(time.January, 1), 1},
+		{day(time.January, 31), 2},
+		{day(time.February, 28), 2},
+		{day(time.March, 1), 0},
+	} {
+		record, ok := Covering(history, test.day)
+		if record.Index != test.index || ok != (test.index != 0) {
+			t.Errorf("%s: expected record %d, got %d, %v", test.day.Format("2006-01-02"), test.index, record.Index, ok)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/budney/budget/budget"
+	bolt "go.etcd.io/bbolt"
+)
+
+// A Batch is one chunk of rows appended, or about to be appended, to a
+// worksheet. It's written to the journal before the rows are sent, and
+// committed once Google says where they went. A batch that was never
+// committed may or may not be in the worksheet, unless it was aborted
+// because its rows certainly weren't appended.
+type Batch struct {
+	ID            string          // Sorts in the order batches were planned
+	Run           string          // The run that planned the batch
+	SpreadsheetID string          // The spreadsheet appended to
+	Worksheet     string          // The worksheet appended to
+	EntryIDs      []string        // The ledger entries the rows came from
+	Rows          [][]interface{} // The rows, exactly as they were sent
+	Planned       time.Time       // When the batch was planned
+	Committed     time.Time       // When it was committed; zero until then
+	UpdatedRange  string          // Where the rows were written, once committed
+	Undone        time.Time       // When the rows were deleted again, if they were
+	Aborted       time.Time       // When it was given up on, if it was
+	Reason        string          // Why it was given up on
+}
+
+// RunID returns the ID of a run started at the specified time. It's
+// precise to the nanosecond, so runs started in the same second get
+// different IDs, and fixed width, so IDs sort in the order runs started.
+func RunID(now time.Time) string {
+	return now.Format("20060102-150405.000000000")
+}
+
+// A Journal records the appends of one run in the ledger. It satisfies
+// budget.Journal.
+type Journal struct {
+	ledger  *Ledger
+	run     string
+	sources []string        // Where the transactions came from
+	entries map[string]bool // The entries they may be, if from several sources
+}
+
+// Journal returns a journal for the appends of the specified run, whose
+// transactions came from the specified source.
+func (ledger *Ledger) Journal(run string, source string) *Journal {
+	return &Journal{ledger: ledger, run: run, sources: []string{source}}
+}
+
+// EntriesJournal returns a journal for the appends of the specified run,
+// whose transactions are the specified entries, from any sources, as when
+// a worksheet is rebuilt from the ledger.
+func (ledger *Ledger) EntriesJournal(run string, entries []Entry) *Journal {
+	journal := &Journal{ledger: ledger, run: run, entries: make(map[string]bool, len(entries))}
+
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		journal.entries[entry.ID] = true
+		if !seen[entry.Source] {
+			seen[entry.Source] = true
+			journal.sources = append(journal.sources, entry.Source)
+		}
+	}
+
+	return journal
+}
+
+// id returns the ID of the entry a transaction came from.
+func (journal *Journal) id(transaction budget.Transaction) string {
+	if journal.entries == nil {
+		return ID(journal.sources[0], transaction)
+	}
+
+	for _, source := range journal.sources {
+		if id := ID(source, transaction); journal.entries[id] {
+			return id
+		}
+	}
+	return ""
+}
+
+// Plan records a batch of rows that's about to be appended, and returns
+// its ID.
+func (journal *Journal) Plan(spreadsheetID string, worksheet string, transactions []budget.Transaction, rows [][]interface{}) (string, error) {
+	batch := Batch{
+		Run:           journal.run,
+		SpreadsheetID: spreadsheetID,
+		Worksheet:     worksheet,
+		EntryIDs:      make([]string, len(transactions)),
+		Rows:          rows,
+		Planned:       time.Now(),
+	}
+	for i, transaction := range transactions {
+		batch.EntryIDs[i] = journal.id(transaction)
+	}
+
+	err := journal.ledger.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(journalBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		batch.ID = fmt.Sprintf("%012d", seq)
+
+		return putBatch(bucket, batch)
+	})
+
+	return batch.ID, err
+}
+
+// Commit records that a batch was appended to the specified range.
+func (journal *Journal) Commit(batch string, updatedRange string) error {
+	return journal.ledger.CommitBatch(batch, updatedRange)
+}
+
+// Abort records that a batch's rows weren't appended, and won't be.
+func (journal *Journal) Abort(batch string, reason error) error {
+	return journal.ledger.AbortBatch(batch, reason.Error())
+}
+
+// AbortBatch gives up on a batch that was never committed, so it's no
+// longer pending and is never replayed. Its entries aren't marked as
+// projected, so the next sync sends them again.
+func (ledger *Ledger) AbortBatch(id string, reason string) error {
+	return ledger.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(journalBucket)
+		batch, err := getBatch(bucket, id)
+		if err != nil {
+			return err
+		}
+		if !batch.Committed.IsZero() {
+			return fmt.Errorf("journal batch %s was committed, so it can't be aborted", id)
+		}
+
+		batch.Aborted = time.Now()
+		batch.Reason = reason
+		return putBatch(bucket, batch)
+	})
+}
+
+// CommitBatch records that a batch was appended to the specified range,
+// and that its entries have been written to its worksheet. Both happen
+// at once, so a batch is never replayed after its entries are marked.
+func (ledger *Ledger) CommitBatch(id string, updatedRange string) error {
+	return ledger.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(journalBucket)
+		batch, err := getBatch(bucket, id)
+		if err != nil {
+			return err
+		}
+		batch.Committed = time.Now()
+		batch.UpdatedRange = updatedRange
+		if err = putBatch(bucket, batch); err != nil {
+			return err
+		}
+
+		// Entries that aren't in the ledger have nothing to mark
+		entries := tx.Bucket(entriesBucket)
+		target := Target(batch.SpreadsheetID, batch.Worksheet)
+		for _, entryID := range batch.EntryIDs {
+			entry, err := get(entries, entryID)
+			if err != nil || entry.Projected(target) {
+				continue
+			}
+
+			entry.Projections = append(entry.Projections, target)
+			if err = put(entries, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Pending returns the batches that were planned but never committed or
+// aborted, in the order they were planned.
+func (ledger *Ledger) Pending() ([]Batch, error) {
+	var pending []Batch
+
+	err := ledger.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(journalBucket).ForEach(func(k, v []byte) error {
+			var batch Batch
+			if err := json.Unmarshal(v, &batch); err != nil {
+				return err
+			}
+			if batch.Committed.IsZero() && batch.Aborted.IsZero() {
+				pending = append(pending, batch)
+			}
+			return nil
+		})
+	})
+
+	return pending, err
+}
+
+// getBatch reads a batch from the journal bucket.
+func getBatch(bucket *bolt.Bucket, id string) (Batch, error) {
+	var batch Batch
+
+	b := bucket.Get([]byte(id))
+	if b == nil {
+		return batch, fmt.Errorf("no journal batch %s", id)
+	}
+
+	err := json.Unmarshal(b, &batch)
+	return batch, err
+}
+
+// putBatch writes a batch to the journal bucket.
+func putBatch(bucket *bolt.Bucket, batch Batch) error {
+	b, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(batch.ID), b)
+}
@@ -0,0 +1,201 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ledger
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/budney/budget/budget"
+)
+
+// Planned batches are pending until they're committed, and committing
+// them marks their entries as projected
+func TestJournal(t *testing.T) {
+	ledger, cleanup := openTemp(t)
+	defer cleanup()
+
+	now := time.Now()
+	transaction := budget.Transaction{Date: now, Account: "Checking", Description: "Coffee", DebitPennies: 500}
+	entry, err := ledger.Record("td", transaction, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	journal := ledger.Journal(RunID(now), "td")
+	rows := [][]interface{}{{"Food", 1, "1/2/2018", "POS", "Coffee", 5.0, 0.0, 100.0}}
+	id, err := journal.Plan("sheet", "Checking", []budget.Transaction{transaction}, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := ledger.Pending()
+	if err != nil || len(pending) != 1 || pending[0].ID != id || len(pending[0].Rows) != 1 {
+		t.Fatalf("Expected one pending batch, got %v, %v", pending, err)
+	}
+
+	if err = journal.Commit(id, "Checking!A2:H2"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Committed", func(t *testing.T) {
+		pending, err := ledger.Pending()
+		if err != nil || len(pending) != 0 {
+			t.Errorf("Expected no pending batches, got %v, %v", pending, err)
+		}
+	})
+
+	t.Run("Projected", func(t *testing.T) {
+		entries, err := ledger.Between(now, now)
+		if err != nil || len(entries) != 1 || entries[0].ID != entry.ID || !entries[0].Projected(Target("sheet", "Checking")) {
+			t.Errorf("Expected a projected entry, got %v, %v", entries, err)
+		}
+	})
+}
+
+// A journal of entries from several sources finds each transaction's
+// entry, whichever source it came from
+func TestEntriesJournal(t *testing.T) {
+	ledger, cleanup := openTemp(t)
+	defer cleanup()
+
+	now := time.Now()
+	coffee := budget.Transaction{Date: now, Account: "Checking", Description: "Coffee", DebitPennies: 500}
+	rent := budget.Transaction{Date: now, Account: "Checking", Description: "Rent", DebitPennies: 100000}
+	first, err := ledger.Record("td", coffee, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ledger.Record("import:ofx", rent, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	journal := ledger.EntriesJournal(RunID(now), []Entry{first, second})
+	lunch := budget.Transaction{Date: now, Account: "Checking", Description: "Lunch", DebitPennies: 1200}
+	rows := [][]interface{}{{}, {}, {}}
+	id, err := journal.Plan("sheet", "Checking", []budget.Transaction{coffee, rent, lunch}, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := ledger.Pending()
+	if err != nil || len(pending) != 1 || pending[0].ID != id {
+		t.Fatalf("Expected one pending batch, got %v, %v", pending, err)
+	}
+	ids := pending[0].EntryIDs
+	if len(ids) != 3 || ids[0] != first.ID || ids[1] != second.ID || ids[2] != "" {
+		t.Errorf("Expected entry IDs %s, %s and none, got %v", first.ID, second.ID, ids)
+	}
+}
+
+// Aborted batches aren't pending, and their entries aren't projected
+func TestAbort(t *testing.T) {
+	ledger, cleanup := openTemp(t)
+	defer cleanup()
+
+	now := time.Now()
+	transaction := budget.Transaction{Date: now, Account: "Checking", Description: "Coffee", DebitPennies: 500}
+	if _, err := ledger.Record("td", transaction, now); err != nil {
+		t.Fatal(err)
+	}
+
+	journal := ledger.Journal(RunID(now), "td")
+	id, err := journal.Plan("sheet", "Checking", []budget.Transaction{transaction}, [][]interface{}{{"Food"}})
+	if err == nil {
+		err = journal.Abort(id, errors.New("no such worksheet"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pending, err := ledger.Pending(); err != nil || len(pending) != 0 {
+		t.Errorf("Expected no pending batches, got %v, %v", pending, err)
+	}
+	if entries, err := ledger.Between(now, now); err != nil || len(entries) != 1 || entries[0].Projected(Target("sheet", "Checking")) {
+		t.Errorf("Expected an unprojected entry, got %v, %v", entries, err)
+	}
+	if err = journal.Commit(id, "Checking!A2:H2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ledger.AbortBatch(id, "too late"); err == nil {
+		t.Error("Expected an error aborting a committed batch")
+	}
+}
+
+// Undoing a run's batches unmarks their entries, and undone runs aren't
+// the last run any more
+func TestUndo(t *testing.T) {
+	ledger, cleanup := openTemp(t)
+	defer cleanup()
+
+	now := time.Now()
+	transaction := budget.Transaction{Date: now, Account: "Checking", Description: "Coffee", DebitPennies: 500}
+	if _, err := ledger.Record("td", transaction, now); err != nil {
+		t.Fatal(err)
+	}
+
+	run := Run{ID: RunID(now), Started: now}
+	if err := ledger.SaveRun(run); err != nil {
+		t.Fatal(err)
+	}
+
+	journal := ledger.Journal(run.ID, "td")
+	id, err := journal.Plan("sheet", "Checking", []budget.Transaction{transaction}, [][]interface{}{{"Food"}})
+	if err == nil {
+		err = journal.Commit(id, "Checking!A2:H2")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batches, err := ledger.Batches(run.ID)
+	if err != nil || len(batches) != 1 || batches[0].UpdatedRange != "Checking!A2:H2" {
+		t.Fatalf("Expected one committed batch, got %v, %v", batches, err)
+	}
+	if last, err := ledger.LastRun(); err != nil || last.ID != run.ID {
+		t.Errorf("Expected last run %s, got %v, %v", run.ID, last, err)
+	}
+
+	if err = ledger.UndoBatch(id); err != nil {
+		t.Fatal(err)
+	}
+	run.Undone = now
+	if err = ledger.SaveRun(run); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Batches", func(t *testing.T) {
+		batches, err := ledger.Batches(run.ID)
+		if err != nil || len(batches) != 0 {
+			t.Errorf("Expected no batches, got %v, %v", batches, err)
+		}
+	})
+
+	t.Run("Unprojected", func(t *testing.T) {
+		entries, err := ledger.Between(now, now)
+		if err != nil || len(entries) != 1 || entries[0].Projected(Target("sheet", "Checking")) {
+			t.Errorf("Expected an unprojected entry, got %v, %v", entries, err)
+		}
+	})
+
+	t.Run("LastRun", func(t *testing.T) {
+		if _, err := ledger.LastRun(); err == nil {
+			t.Error("Expected no runs to undo")
+		}
+	})
+}
+
+// Test that runs started in the same second get IDs that sort in order
+func TestRunID(t *testing.T) {
+	start := time.Date(2018, time.January, 2, 3, 4, 5, 0, time.Local)
+	ids := []string{RunID(start), RunID(start.Add(time.Millisecond)), RunID(start.Add(time.Second))}
+	for i := 1; i < len(ids); i++ {
+		if ids[i-1] >= ids[i] {
+			t.Errorf("Expected %s to sort before %s", ids[i-1], ids[i])
+		}
+	}
+}
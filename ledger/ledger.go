@@ -0,0 +1,373 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ledger provides a local database of every transaction ever
+// downloaded, which is the source of truth for the budget. Budget
+// worksheets are projections of the ledger: the ledger remembers which
+// transactions have been written to which worksheets, so a worksheet can
+// be brought up to date, or rebuilt from scratch, without reading it
+// back from Google.
+//
+// The database is a single bbolt file. Transactions are keyed by a
+// stable ID derived from their contents, so downloading the same
+// transaction twice only updates when it was last seen.
+package ledger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/budney/budget/budget"
+	"github.com/budney/budget/pipeline"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket names
+var (
+	entriesBucket = []byte("entries") // ID => Entry, as JSON
+	byDateBucket  = []byte("by-date") // date|account|ID => ID
+	journalBucket = []byte("journal") // Batch ID => Batch, as JSON
+	runsBucket    = []byte("runs")    // Run ID => Run, as JSON
+)
+
+// An Entry is one transaction in the ledger.
+type Entry struct {
+	ID          string             // Stable ID, from the source and contents
+	Source      string             // Where the transaction was downloaded from
+	Transaction budget.Transaction // The transaction itself
+	FirstSeen   time.Time          // When the transaction was first downloaded
+	LastSeen    time.Time          // When it was most recently downloaded
+	Projections []string           // The worksheets it has been written to
+}
+
+// Projected reports whether the entry has been written to the target
+// worksheet. Targets are named by the Target function.
+func (entry Entry) Projected(target string) bool {
+	for _, projection := range entry.Projections {
+		if projection == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Target names a worksheet in a spreadsheet, for recording projections.
+func Target(spreadsheetID string, worksheet string) string {
+	return spreadsheetID + "!" + worksheet
+}
+
+// ID returns the stable ID of a transaction from the specified source.
+// If the source gave the transaction a reference, it's a hash of the
+// account and reference. Otherwise it's a hash of pipeline.Key, so it
+// ignores the same fields, and identical transactions on the same day
+// are told apart by their Occurrence. It uses the description as the
+// source gave it, so that configuring pipeline stages that tidy
+// descriptions up doesn't change any IDs.
+func ID(source string, transaction budget.Transaction) string {
+	key := ""
+	if transaction.Reference != "" {
+		key = "ref|" + transaction.Account + "|" + transaction.Reference
+	} else {
+		if transaction.RawDescription != "" {
+			transaction.Description = transaction.RawDescription
+		}
+		key = pipeline.Key(transaction)
+	}
+
+	sum := sha256.Sum256([]byte(source + "|" + key))
+	return hex.EncodeToString(sum[:16])
+}
+
+// A Ledger is an open ledger database.
+type Ledger struct {
+	db *bolt.DB
+}
+
+// Open opens the ledger in the specified file, creating it if needed.
+// Only one process can have a ledger open at a time; Open waits up to
+// a few seconds for another process to close it.
+func Open(fileName string) (*Ledger, error) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(fileName, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("ledger %s: %v", fileName, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{entriesBucket, byDateBucket, journalBucket, runsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ledger %s: %v", fileName, err)
+	}
+
+	return &Ledger{db: db}, nil
+}
+
+// Close closes the ledger.
+func (ledger *Ledger) Close() error {
+	return ledger.db.Close()
+}
+
+// Record adds a transaction from the specified source to the ledger, or
+// updates its LastSeen time if it's already there. It returns the entry
+// as stored.
+func (ledger *Ledger) Record(source string, transaction budget.Transaction, now time.Time) (Entry, error) {
+	var entry Entry
+
+	err := ledger.db.Update(func(tx *bolt.Tx) error {
+		id := ID(source, transaction)
+		entries := tx.Bucket(entriesBucket)
+
+		if b := entries.Get([]byte(id)); b != nil {
+			if err := json.Unmarshal(b, &entry); err != nil {
+				return err
+			}
+		} else {
+			entry = Entry{ID: id, Source: source, Transaction: transaction, FirstSeen: now}
+			if err := tx.Bucket(byDateBucket).Put(dateKey(entry), []byte(id)); err != nil {
+				return err
+			}
+		}
+
+		entry.LastSeen = now
+		return put(entries, entry)
+	})
+
+	return entry, err
+}
+
+// MarkProjected records that the specified entries have been written to
+// the target worksheet.
+func (ledger *Ledger) MarkProjected(ids []string, target string) error {
+	return ledger.db.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(entriesBucket)
+
+		for _, id := range ids {
+			entry, err := get(entries, id)
+			if err != nil {
+				return err
+			}
+			if !entry.Projected(target) {
+				entry.Projections = append(entry.Projections, target)
+				if err = put(entries, entry); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// UnmarkProjected records that the specified entries are no longer in
+// the target worksheet, e.g. because it was cleared.
+func (ledger *Ledger) UnmarkProjected(ids []string, target string) error {
+	return ledger.db.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(entriesBucket)
+
+		for _, id := range ids {
+			entry, err := get(entries, id)
+			if err != nil {
+				return err
+			}
+
+			kept := entry.Projections[:0]
+			for _, projection := range entry.Projections {
+				if projection != target {
+					kept = append(kept, projection)
+				}
+			}
+			entry.Projections = kept
+
+			if err = put(entries, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Between returns the entries dated between start and end, inclusive, in
+// date order. If accounts are listed, only entries from those accounts
+// are returned.
+func (ledger *Ledger) Between(start time.Time, end time.Time, accounts ...string) ([]Entry, error) {
+	var result []Entry
+
+	keep := make(map[string]bool, len(accounts))
+	for _, account := range accounts {
+		keep[account] = true
+	}
+
+	first := []byte(start.Format("2006-01-02"))
+	last := end.Format("2006-01-02") + "|\xff"
+
+	err := ledger.db.View(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(entriesBucket)
+		cursor := tx.Bucket(byDateBucket).Cursor()
+
+		for k, id := cursor.Seek(first); k != nil && string(k) <= last; k, id = cursor.Next() {
+			entry, err := get(entries, string(id))
+			if err != nil {
+				return err
+			}
+			if len(keep) == 0 || keep[entry.Transaction.Account] {
+				result = append(result, entry)
+			}
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+// A Projection records transactions in the ledger as they pass through
+// a pipeline, and passes on only those that haven't been written to its
+// target worksheet. Once they have been written, Commit records that.
+type Projection struct {
+	ledger *Ledger
+	source string
+	target string
+	now    time.Time
+
+	lock sync.Mutex
+	ids  []string
+	err  error
+}
+
+// Project returns a projection of transactions from the specified source
+// to the target worksheet.
+func (ledger *Ledger) Project(source string, target string, now time.Time) *Projection {
+	return &Projection{ledger: ledger, source: source, target: target, now: now}
+}
+
+// Stage returns the pipeline stage that records transactions. If the
+// ledger can't be written, the transaction is dropped, and the error is
+// returned by Commit.
+func (projection *Projection) Stage() *pipeline.Stage {
+	return pipeline.NewStage("ledger", func(transaction *budget.Transaction) bool {
+		entry, err := projection.ledger.Record(projection.source, *transaction, projection.now)
+
+		projection.lock.Lock()
+		defer projection.lock.Unlock()
+
+		if err != nil {
+			if projection.err == nil {
+				projection.err = err
+			}
+			return false
+		}
+		if entry.Projected(projection.target) {
+			return false
+		}
+
+		projection.ids = append(projection.ids, entry.ID)
+		return true
+	})
+}
+
+// IDs returns the IDs of the transactions passed on so far.
+func (projection *Projection) IDs() []string {
+	projection.lock.Lock()
+	defer projection.lock.Unlock()
+
+	return append([]string(nil), projection.ids...)
+}
+
+// Commit records that every transaction passed on by the stage has been
+// written to the target worksheet. If the stage failed to record any
+// transactions, Commit returns the first error instead.
+func (projection *Projection) Commit() error {
+	projection.lock.Lock()
+	err := projection.err
+	projection.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return projection.ledger.MarkProjected(projection.IDs(), projection.target)
+}
+
+// CommitConfirmed records that the transactions passed on by the stage
+// that the run's journal confirms were appended to the target worksheet,
+// because they're in a batch committed with an UpdatedRange, have been
+// written to it. It's for when an append failed partway, and Commit
+// would mark transactions that never arrived. It returns how many of
+// the transactions were confirmed.
+func (projection *Projection) CommitConfirmed(run string) (int, error) {
+	confirmed := make(map[string]bool)
+	err := projection.ledger.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(journalBucket).ForEach(func(k, v []byte) error {
+			var batch Batch
+			if err := json.Unmarshal(v, &batch); err != nil {
+				return err
+			}
+			if batch.Run != run || batch.Committed.IsZero() || batch.UpdatedRange == "" ||
+				Target(batch.SpreadsheetID, batch.Worksheet) != projection.target {
+				return nil
+			}
+
+			for _, id := range batch.EntryIDs {
+				confirmed[id] = true
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []string
+	for _, id := range projection.IDs() {
+		if confirmed[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	return len(ids), projection.ledger.MarkProjected(ids, projection.target)
+}
+
+// dateKey returns the by-date key of an entry, which sorts by date and
+// then account.
+func dateKey(entry Entry) []byte {
+	return []byte(entry.Transaction.Date.Format("2006-01-02") + "|" + entry.Transaction.Account + "|" + entry.ID)
+}
+
+// get reads an entry from the entries bucket.
+func get(entries *bolt.Bucket, id string) (Entry, error) {
+	var entry Entry
+
+	b := entries.Get([]byte(id))
+	if b == nil {
+		return entry, fmt.Errorf("no ledger entry %s", id)
+	}
+
+	err := json.Unmarshal(b, &entry)
+	return entry, err
+}
+
+// put writes an entry to the entries bucket.
+func put(entries *bolt.Bucket, entry Entry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return entries.Put([]byte(entry.ID), b)
+}
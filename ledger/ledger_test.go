@@ -0,0 +1,208 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ledger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/budney/budget/budget"
+	"github.com/budney/budget/pipeline"
+)
+
+// openTemp opens a ledger in a temporary directory
+func openTemp(t *testing.T) (*Ledger, func()) {
+	dir, err := ioutil.TempDir("", "ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ledger, err := Open(filepath.Join(dir, "ledger.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return ledger, func() {
+		ledger.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// Recording the same transaction twice keeps one entry
+func TestRecord(t *testing.T) {
+	ledger, cleanup := openTemp(t)
+	defer cleanup()
+
+	day := time.Date(2018, time.January, 2, 0, 0, 0, 0, time.Local)
+	first := time.Date(2018, time.January, 3, 8, 0, 0, 0, time.Local)
+	second := first.Add(24 * time.Hour)
+	transaction := budget.Transaction{Date: day, Account: "Checking", Description: "Coffee", DebitPennies: 500}
+
+	a, err := ledger.Record("td", transaction, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ledger.Record("td", transaction, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.ID != b.ID || !b.FirstSeen.Equal(first) || !b.LastSeen.Equal(second) {
+		t.Errorf("Wrong entries: %+v, %+v", a, b)
+	}
+
+	entries, err := ledger.Between(day, day)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Expected 1 entry, got %v, %v", entries, err)
+	}
+}
+
+// Projected transactions aren't passed on a second time
+func TestProjection(t *testing.T) {
+	ledger, cleanup := openTemp(t)
+	defer cleanup()
+
+	now := time.Now()
+	transaction := budget.Transaction{Date: now, Account: "Checking", Description: "Coffee", DebitPennies: 500}
+	target := Target("sheet", "Checking")
+
+	projection := ledger.Project("td", target, now)
+	stage := projection.Stage()
+	if !stage.Func(&transaction) {
+		t.Fatal("New transaction should pass")
+	}
+	if err := projection.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	projection = ledger.Project("td", target, now)
+	if projection.Stage().Func(&transaction) {
+		t.Error("Projected transaction should be dropped")
+	}
+
+	projection = ledger.Project("td", Target("sheet", "Other"), now)
+	if !projection.Stage().Func(&transaction) {
+		t.Error("Transaction should pass to a different worksheet")
+	}
+}
+
+// After a failed append, only what the journal confirms is projected
+func TestCommitConfirmed(t *testing.T) {
+	ledger, cleanup := openTemp(t)
+	defer cleanup()
+
+	now := time.Now()
+	run := RunID(now)
+	coffee := budget.Transaction{Date: now, Account: "Checking", Description: "Coffee", DebitPennies: 500}
+	rent := budget.Transaction{Date: now, Account: "Checking", Description: "Rent", DebitPennies: 100000}
+	target := Target("sheet", "Checking")
+
+	projection := ledger.Project("td", target, now)
+	stage := projection.Stage()
+	if !stage.Func(&coffee) || !stage.Func(&rent) {
+		t.Fatal("New transactions should pass")
+	}
+
+	// Only the first batch made it
+	journal := ledger.Journal(run, "td")
+	id, err := journal.Plan("sheet", "Checking", []budget.Transaction{coffee}, [][]interface{}{{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = journal.Commit(id, "Checking!A2:H2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = journal.Plan("sheet", "Checking", []budget.Transaction{rent}, [][]interface{}{{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if confirmed, err := projection.CommitConfirmed(run); err != nil || confirmed != 1 {
+		t.Fatalf("Expected 1 confirmed transaction, got %d, %v", confirmed, err)
+	}
+
+	projection = ledger.Project("td", target, now)
+	if projection.Stage().Func(&coffee) {
+		t.Error("Confirmed transaction should be dropped")
+	}
+	if !projection.Stage().Func(&rent) {
+		t.Error("Unconfirmed transaction should pass")
+	}
+}
+
+// Between filters by date and account
+func TestBetween(t *testing.T) {
+	ledger, cleanup := openTemp(t)
+	defer cleanup()
+
+	now := time.Now()
+	for day := 1; day <= 5; day++ {
+		for _, account := range []string{"Checking", "Savings"} {
+			date := time.Date(2018, time.January, day, 0, 0, 0, 0, time.Local)
+			ledger.Record("td", budget.Transaction{Date: date, Account: account}, now)
+		}
+	}
+
+	start := time.Date(2018, time.January, 2, 0, 0, 0, 0, time.Local)
+	end := time.Date(2018, time.January, 4, 0, 0, 0, 0, time.Local)
+
+	t.Run("All", func(t *testing.T) {
+		if entries, _ := ledger.Between(start, end); len(entries) != 6 {
+			t.Errorf("Expected 6 entries, got %d", len(entries))
+		}
+	})
+	t.Run("Account", func(t *testing.T) {
+		entries, _ := ledger.Between(start, end, "Savings")
+		if len(entries) != 3 || !entries[0].Transaction.Date.Equal(start) {
+			t.Errorf("Expected 3 entries from Jan 2, got %v", entries)
+		}
+	})
+}
+
+// IDs don't change when pipeline stages tidy up descriptions
+func TestID(t *testing.T) {
+	day := time.Date(2018, time.January, 2, 0, 0, 0, 0, time.Local)
+	raw := budget.Transaction{Date: day, Account: "Checking", Description: "  WALMART   #1234 ", DebitPennies: 1000}
+
+	tidied := raw
+	tidied.RawDescription = raw.Description
+	tidied.Description = "WALMART #1234"
+	tidied.Category = "Groceries"
+
+	if ID("td", raw) != ID("td", tidied) {
+		t.Error("Expected the same ID for the raw and tidied transaction")
+	}
+	if ID("td", raw) == ID("import", raw) {
+		t.Error("Expected different IDs for different sources")
+	}
+
+	t.Run("Occurrence", func(t *testing.T) {
+		coffees := []budget.Transaction{raw, raw}
+		pipeline.NumberOccurrences(coffees)
+		if ID("qif", coffees[0]) == ID("qif", coffees[1]) {
+			t.Error("Expected different IDs for two identical purchases")
+		}
+		if ID("qif", coffees[0]) != ID("qif", raw) {
+			t.Error("Expected the first occurrence to keep its ID")
+		}
+	})
+	t.Run("Reference", func(t *testing.T) {
+		booked := raw
+		booked.Reference = "2018010200042"
+		pending := booked
+		pending.Description = "WALMART"
+		pending.BalancePennies = 5000
+		if ID("camt", booked) != ID("camt", pending) {
+			t.Error("Expected the same ID for the same reference")
+		}
+		pending.Reference = "2018010200043"
+		if ID("camt", booked) == ID("camt", pending) {
+			t.Error("Expected different IDs for different references")
+		}
+	})
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/budney/budget/budget"
+	bolt "go.etcd.io/bbolt"
+)
+
+// A Run records what a sync or rebuild changed besides the rows it
+// appended, which are in its journal batches, so that it can be undone.
+type Run struct {
+	ID      string             // From RunID, so runs sort by when they started
+	Started time.Time          // When the run started
+	IndexID string             // The index spreadsheet it updated
+	Records []RunRecord        // The index records of the spreadsheets it appended to
+	Cleared []ClearedWorksheet // The worksheets it cleared, before appending to them
+	Undone  time.Time          // When the run was undone, if it was
+}
+
+// A ClearedWorksheet is what a worksheet held before a rebuild cleared
+// it, so that it can be put back.
+type ClearedWorksheet struct {
+	SpreadsheetID string
+	Worksheet     string
+	Transactions  []budget.Transaction // The worksheet's rows, with their categories
+	EntryIDs      []string             // The ledger entries that were projected to it
+}
+
+// A RunRecord is an index record a run may have updated.
+type RunRecord struct {
+	IndexRow            int       // The index record, from index.Record.Index
+	PreviousLastUpdated time.Time // The record's LastUpdated before the run
+	LastUpdated         time.Time // The record's LastUpdated after the run, if it was set
+}
+
+// SaveRun adds or replaces a run.
+func (ledger *Ledger) SaveRun(run Run) error {
+	return ledger.db.Update(func(tx *bolt.Tx) error {
+		b, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(runsBucket).Put([]byte(run.ID), b)
+	})
+}
+
+// Runs returns every run, oldest first.
+func (ledger *Ledger) Runs() ([]Run, error) {
+	var runs []Run
+
+	err := ledger.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(runsBucket).ForEach(func(k, v []byte) error {
+			var run Run
+			if err := json.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			runs = append(runs, run)
+			return nil
+		})
+	})
+
+	return runs, err
+}
+
+// LastRun returns the most recent run that hasn't been undone.
+func (ledger *Ledger) LastRun() (Run, error) {
+	runs, err := ledger.Runs()
+	if err != nil {
+		return Run{}, err
+	}
+
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].Undone.IsZero() {
+			return runs[i], nil
+		}
+	}
+
+	return Run{}, fmt.Errorf("no runs to undo")
+}
+
+// GetRun returns the run with the specified ID.
+func (ledger *Ledger) GetRun(id string) (Run, error) {
+	var run Run
+
+	err := ledger.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket).Get([]byte(id))
+		if b == nil {
+			return fmt.Errorf("no run %s", id)
+		}
+		return json.Unmarshal(b, &run)
+	})
+
+	return run, err
+}
+
+// Batches returns the batches a run committed and hasn't undone, in the
+// order they were planned.
+func (ledger *Ledger) Batches(run string) ([]Batch, error) {
+	var batches []Batch
+
+	err := ledger.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(journalBucket).ForEach(func(k, v []byte) error {
+			var batch Batch
+			if err := json.Unmarshal(v, &batch); err != nil {
+				return err
+			}
+			if batch.Run == run && !batch.Committed.IsZero() && batch.Undone.IsZero() {
+				batches = append(batches, batch)
+			}
+			return nil
+		})
+	})
+
+	return batches, err
+}
+
+// UndoBatch records that a batch's rows were deleted from its worksheet,
+// so its entries are no longer projected there, and the next sync will
+// append them again if they're downloaded again.
+func (ledger *Ledger) UndoBatch(id string) error {
+	return ledger.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(journalBucket)
+		batch, err := getBatch(bucket, id)
+		if err != nil {
+			return err
+		}
+		batch.Undone = time.Now()
+		if err = putBatch(bucket, batch); err != nil {
+			return err
+		}
+
+		entries := tx.Bucket(entriesBucket)
+		target := Target(batch.SpreadsheetID, batch.Worksheet)
+		for _, entryID := range batch.EntryIDs {
+			entry, err := get(entries, entryID)
+			if err != nil {
+				continue
+			}
+
+			kept := entry.Projections[:0]
+			for _, projection := range entry.Projections {
+				if projection != target {
+					kept = append(kept, projection)
+				}
+			}
+			entry.Projections = kept
+
+			if err = put(entries, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
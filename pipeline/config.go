@@ -0,0 +1,89 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pipeline
+
+import (
+	"fmt"
+	"time"
+)
+
+// Config describes one stage of a pipeline, as it's written in the
+// config file. For example, in options.json:
+//
+//	"Pipeline": [
+//	    {"Stage": "normalize-payee", "Payees": [{"Pattern": "AMZN MKTP", "Payee": "Amazon"}]},
+//	    {"Stage": "categorize", "Rules": [{"Pattern": "WALMART", "Category": "Groceries"}]},
+//	    {"Stage": "filter-dates", "Start": "2018-01-01"},
+//	    {"Stage": "dedupe"}
+//	]
+//
+// Enrich stages and Tee aren't configurable: an Enrich stage runs Go
+// code, and Tee splits a pipeline between sinks, which the program
+// decides.
+type Config struct {
+	Stage    string      // The kind of stage: filter-accounts, filter-dates, normalize-payee, categorize or dedupe
+	Accounts []string    // The accounts to keep, for filter-accounts
+	Start    string      // The first date to keep, as YYYY-MM-DD, for filter-dates (default: no limit)
+	End      string      // The last date to keep, as YYYY-MM-DD, for filter-dates (default: no limit)
+	Rules    []Rule      // The category rules, for categorize
+	Payees   []PayeeRule // The payee rewrite rules, for normalize-payee
+	Workers  int         // The most transactions to process at once (default 1)
+}
+
+// FromConfig builds a pipeline from a list of stage configs, in order.
+func FromConfig(configs []Config) (*Pipeline, error) {
+	pipeline := New()
+
+	for i, config := range configs {
+		var stage *Stage
+		var err error
+
+		switch config.Stage {
+		case "filter-accounts":
+			stage = AccountFilter(config.Accounts...)
+		case "filter-dates":
+			stage, err = configDateFilter(config.Start, config.End)
+		case "normalize-payee":
+			stage, err = NormalizePayee(config.Payees)
+		case "categorize":
+			stage, err = Categorize(config.Rules)
+		case "dedupe":
+			stage = Dedupe()
+		default:
+			err = fmt.Errorf("unknown stage %q", config.Stage)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pipeline stage %d: %v", i+1, err)
+		}
+
+		if config.Workers > 1 {
+			stage.Workers = config.Workers
+		}
+		pipeline.Append(stage)
+	}
+
+	return pipeline, nil
+}
+
+// configDateFilter returns a DateFilter for dates written as YYYY-MM-DD.
+// An empty date means no limit.
+func configDateFilter(start string, end string) (*Stage, error) {
+	first := time.Time{}
+	last := time.Date(9999, time.December, 31, 0, 0, 0, 0, time.Local)
+
+	var err error
+	if start != "" {
+		if first, err = time.ParseInLocation("2006-01-02", start, time.Local); err != nil {
+			return nil, fmt.Errorf("start date %q: %v", start, err)
+		}
+	}
+	if end != "" {
+		if last, err = time.ParseInLocation("2006-01-02", end, time.Local); err != nil {
+			return nil, fmt.Errorf("end date %q: %v", end, err)
+		}
+	}
+
+	return DateFilter(first, last), nil
+}
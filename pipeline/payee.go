@@ -0,0 +1,149 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pipeline
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/budney/budget/budget"
+)
+
+// A PayeeRule rewrites the payee of transactions whose description
+// matches a regular expression.
+type PayeeRule struct {
+	Pattern  string // A regular expression, matched without regard to case
+	Payee    string // The payee; "$1" and so on are replaced by the pattern's groups
+	Location string // The location, if not empty; groups are replaced as in Payee
+
+	pattern *regexp.Regexp
+}
+
+// cardSuffix matches a masked card number, like "*****1234".
+var cardSuffix = regexp.MustCompile(`(?:\*|X){3,}(\d{4})\b`)
+
+// payeeNoise matches the prefixes banks put before the payee, like
+// "DEBIT CARD PURCHASE, AUT 010218 VISA DDA PUR". They're removed one
+// after another, from the front, until none match.
+var payeeNoise = []*regexp.Regexp{
+	regexp.MustCompile(`^(?i)(?:DEBIT|CREDIT|CHECK) CARD (?:PURCHASE|REFUND|CREDIT|PAYMENT|WITHDRAWAL)\b`),
+	regexp.MustCompile(`^(?i)(?:POS|ACH|ONLINE|ELECTRONIC|RECURRING) (?:DEBIT|CREDIT|PURCHASE|PAYMENT|PMT)(?:-WEB)?\b`),
+	regexp.MustCompile(`^(?i)AUT \d{6}\b`),
+	regexp.MustCompile(`^(?i)(?:VISA )?DDA (?:PURCHASE|PUR|REFUND|REF|WITHDRAW)(?: AP)?\b`),
+	regexp.MustCompile(`^[\s,*-]+`),
+}
+
+// payeeState matches the state at the end of a card purchase, as in
+// "WALMART #1234 ANYTOWN * NY".
+var payeeState = regexp.MustCompile(`\s*\*\s*([A-Za-z]{2})$`)
+
+// storeNumber matches a store number, as in "WALMART #1234".
+var storeNumber = regexp.MustCompile(`\s*#\s*\d+\b`)
+
+// A PayeeNormalizer extracts a payee, location and card suffix from
+// transactions' descriptions, which banks fill with card numbers,
+// authorization codes and other noise. For example, from
+//
+//	DEBIT CARD PURCHASE, *****1234, AUT 010218 VISA DDA PUR WALMART #1234 ANYTOWN * NY
+//
+// it extracts the payee "WALMART", the location "ANYTOWN, NY" and the
+// card suffix "1234". The description itself is left alone.
+type PayeeNormalizer struct {
+	rules []PayeeRule
+}
+
+// NewPayeeNormalizer returns a normalizer that tries the rules, in
+// order, before the built-in ones. The first rule whose pattern matches
+// the description gives the payee, and the location too if it has one.
+func NewPayeeNormalizer(rules []PayeeRule) (*PayeeNormalizer, error) {
+	compiled := make([]PayeeRule, len(rules))
+	for i, rule := range rules {
+		pattern, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("payee rule %q: %v", rule.Pattern, err)
+		}
+
+		compiled[i] = rule
+		compiled[i].pattern = pattern
+	}
+
+	return &PayeeNormalizer{rules: compiled}, nil
+}
+
+// Normalize sets a transaction's payee, location and card suffix from
+// its description, which it doesn't change.
+func (normalizer *PayeeNormalizer) Normalize(transaction *budget.Transaction) {
+	description := strings.Join(strings.Fields(transaction.Description), " ")
+	transaction.Payee, transaction.Location, transaction.CardSuffix = ParsePayee(description)
+
+	for _, rule := range normalizer.rules {
+		match := rule.pattern.FindStringSubmatchIndex(description)
+		if match == nil {
+			continue
+		}
+
+		expand := func(template string) string {
+			return string(rule.pattern.ExpandString(nil, template, description, match))
+		}
+		transaction.Payee = expand(rule.Payee)
+		if rule.Location != "" {
+			transaction.Location = expand(rule.Location)
+		}
+		break
+	}
+}
+
+// ParsePayee extracts the payee, location and card suffix from a
+// description, using the built-in rules. The location and card suffix
+// are empty if the description doesn't have them. If nothing is left of
+// the description once the noise is removed, the payee is the whole
+// description.
+func ParsePayee(description string) (payee string, location string, card string) {
+	s := strings.Join(strings.Fields(description), " ")
+
+	if match := cardSuffix.FindStringSubmatchIndex(s); match != nil {
+		card = s[match[2]:match[3]]
+		s = s[:match[0]] + " " + s[match[1]:]
+	}
+
+	for removed := true; removed; {
+		removed = false
+		for _, noise := range payeeNoise {
+			if match := noise.FindStringIndex(s); match != nil && match[1] > 0 {
+				s = strings.TrimSpace(s[match[1]:])
+				removed = true
+			}
+		}
+	}
+
+	// "PAYEE #1234 CITY * ST": the city follows the store number, or
+	// else it's the last word before the state
+	if match := payeeState.FindStringSubmatchIndex(s); match != nil {
+		state := strings.ToUpper(s[match[2]:match[3]])
+		s = strings.TrimSpace(s[:match[0]])
+
+		city := ""
+		if store := storeNumber.FindStringIndex(s); store != nil {
+			city = strings.TrimSpace(s[store[1]:])
+			s = s[:store[0]]
+		} else if i := strings.LastIndex(s, " "); i >= 0 {
+			city = s[i+1:]
+			s = s[:i]
+		}
+
+		location = state
+		if city != "" {
+			location = city + ", " + state
+		}
+	}
+
+	payee = strings.Trim(storeNumber.ReplaceAllString(s, ""), " ,*-")
+	if payee == "" {
+		payee = strings.Join(strings.Fields(description), " ")
+	}
+
+	return payee, location, card
+}
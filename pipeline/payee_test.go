@@ -0,0 +1,82 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/budney/budget/budget"
+)
+
+// Test the built-in payee rules
+func TestParsePayee(t *testing.T) {
+	tests := []struct {
+		description, payee, location, card string
+	}{
+		{"DEBIT CARD PURCHASE, *****1234, AUT 010218 VISA DDA PUR WALMART #1234 ANYTOWN * NY", "WALMART", "ANYTOWN, NY", "1234"},
+		{"DEBIT CARD PURCHASE, *****5678, AUT 020318 VISA DDA PUR AP   SHELL OIL 57442   SPRINGFIELD * MA", "SHELL OIL 57442", "SPRINGFIELD, MA", "5678"},
+		{"DEBIT CARD REFUND, *****1234, AUT 010518 VISA DDA REF TARGET #0042 * NJ", "TARGET", "NJ", "1234"},
+		{"ELECTRONIC PMT-WEB, NETFLIX.COM", "NETFLIX.COM", "", ""},
+		{"PAYROLL DEPOSIT", "PAYROLL DEPOSIT", "", ""},
+		{"DEBIT CARD PURCHASE", "DEBIT CARD PURCHASE", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.payee, func(t *testing.T) {
+			payee, location, card := ParsePayee(test.description)
+			if payee != test.payee || location != test.location || card != test.card {
+				t.Errorf("Expected %q, %q, %q; got %q, %q, %q", test.payee, test.location, test.card, payee, location, card)
+			}
+		})
+	}
+}
+
+// Test that user rules override the built-in ones
+func TestNormalizePayee(t *testing.T) {
+	normalizer, err := NewPayeeNormalizer([]PayeeRule{
+		{Pattern: `AMZN MKTP (\w+)`, Payee: "Amazon", Location: "Amazon $1"},
+		{Pattern: "walmart", Payee: "Walmart"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Rule", func(t *testing.T) {
+		raw := "DEBIT CARD PURCHASE, *****1234, AUT 010218 VISA DDA PUR  WALMART #1234 ANYTOWN * NY"
+		transaction := budget.Transaction{Description: raw}
+		normalizer.Normalize(&transaction)
+		if transaction.Payee != "Walmart" || transaction.Location != "ANYTOWN, NY" || transaction.CardSuffix != "1234" {
+			t.Errorf("Wrong payee: %+v", transaction)
+		}
+		if transaction.Description != raw {
+			t.Errorf("Description changed: %q", transaction.Description)
+		}
+	})
+	t.Run("Groups", func(t *testing.T) {
+		transaction := budget.Transaction{Description: "AMZN MKTP US*2K4LX0Z31"}
+		normalizer.Normalize(&transaction)
+		if transaction.Payee != "Amazon" || transaction.Location != "Amazon US" {
+			t.Errorf("Wrong payee: %+v", transaction)
+		}
+	})
+	t.Run("Stage", func(t *testing.T) {
+		stage, err := NormalizePayee(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		raw := "  ELECTRONIC PMT-WEB,   NETFLIX.COM "
+		result := run(New(stage), budget.Transaction{Description: raw})
+		if len(result) != 1 || result[0].Description != "ELECTRONIC PMT-WEB, NETFLIX.COM" ||
+			result[0].RawDescription != raw || result[0].Payee != "NETFLIX.COM" {
+			t.Errorf("Wrong transaction: %+v", result)
+		}
+	})
+	t.Run("BadPattern", func(t *testing.T) {
+		if _, err := NormalizePayee([]PayeeRule{{Pattern: "("}}); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pipeline provides reusable stages for processing transactions
+// on their way from a source, such as a bank, to a sink, such as a budget
+// spreadsheet. Each stage reads transactions from one channel and writes
+// them to another, so stages can be chained in any order. Every stage
+// counts the transactions it reads, writes and drops.
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/budney/budget/budget"
+)
+
+// A Func processes one transaction, which it may modify in place. It
+// returns false if the transaction should be dropped.
+type Func func(*budget.Transaction) bool
+
+// A Stage runs a Func on every transaction that passes through it, with
+// up to Workers transactions in progress at once. Stages with more than
+// one worker don't preserve the order of transactions, and their Func
+// must be safe to call concurrently.
+type Stage struct {
+	Name    string // Identifies the stage in counters and logs
+	Func    Func   // Processes each transaction
+	Workers int    // The most transactions to process at once; at least one
+
+	in, out, dropped int64
+}
+
+// Counters holds the number of transactions a stage has read, written
+// and dropped.
+type Counters struct {
+	Name    string
+	In      int64
+	Out     int64
+	Dropped int64
+}
+
+// NewStage returns a stage that runs f with a single worker.
+func NewStage(name string, f Func) *Stage {
+	return &Stage{Name: name, Func: f, Workers: 1}
+}
+
+// Run starts the stage's workers, which read transactions from in until
+// it's closed or ctx is done. It returns the channel the stage writes
+// to, which is closed when the workers are done.
+func (stage *Stage) Run(ctx context.Context, in <-chan budget.Transaction) <-chan budget.Transaction {
+	out := make(chan budget.Transaction)
+
+	workers := stage.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wait sync.WaitGroup
+	wait.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wait.Done()
+			stage.work(ctx, in, out)
+		}()
+	}
+
+	go func() {
+		wait.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// work is the body of one of the stage's workers.
+func (stage *Stage) work(ctx context.Context, in <-chan budget.Transaction, out chan<- budget.Transaction) {
+	for {
+		var transaction budget.Transaction
+		var ok bool
+
+		select {
+		case transaction, ok = <-in:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		atomic.AddInt64(&stage.in, 1)
+		if !stage.Func(&transaction) {
+			atomic.AddInt64(&stage.dropped, 1)
+			continue
+		}
+
+		select {
+		case out <- transaction:
+			atomic.AddInt64(&stage.out, 1)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Counters returns the stage's counters so far.
+func (stage *Stage) Counters() Counters {
+	return Counters{
+		Name:    stage.Name,
+		In:      atomic.LoadInt64(&stage.in),
+		Out:     atomic.LoadInt64(&stage.out),
+		Dropped: atomic.LoadInt64(&stage.dropped),
+	}
+}
+
+// A Pipeline is a series of stages, each reading from the one before.
+type Pipeline struct {
+	Stages []*Stage
+}
+
+// New returns a pipeline made of the specified stages, in order.
+func New(stages ...*Stage) *Pipeline {
+	return &Pipeline{Stages: stages}
+}
+
+// Append adds stages to the end of the pipeline.
+func (pipeline *Pipeline) Append(stages ...*Stage) {
+	pipeline.Stages = append(pipeline.Stages, stages...)
+}
+
+// Run connects the stages, and starts them reading from in. It returns
+// the channel the last stage writes to. A pipeline with no stages just
+// returns in.
+func (pipeline *Pipeline) Run(ctx context.Context, in <-chan budget.Transaction) <-chan budget.Transaction {
+	for _, stage := range pipeline.Stages {
+		in = stage.Run(ctx, in)
+	}
+
+	return in
+}
+
+// Counters returns the counters of every stage, in order.
+func (pipeline *Pipeline) Counters() []Counters {
+	counters := make([]Counters, 0, len(pipeline.Stages))
+	for _, stage := range pipeline.Stages {
+		counters = append(counters, stage.Counters())
+	}
+
+	return counters
+}
+
+// Tee copies every transaction from in to n output channels, so that one
+// pipeline can feed several sinks. A slow sink slows them all down. The
+// outputs are closed when in is closed or ctx is done.
+func Tee(ctx context.Context, in <-chan budget.Transaction, n int) []<-chan budget.Transaction {
+	outs := make([]chan budget.Transaction, n)
+	result := make([]<-chan budget.Transaction, n)
+	for i := range outs {
+		outs[i] = make(chan budget.Transaction)
+		result[i] = outs[i]
+	}
+
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+
+		for transaction := range in {
+			for _, out := range outs {
+				select {
+				case out <- transaction:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return result
+}
@@ -0,0 +1,155 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/budney/budget/budget"
+)
+
+// run sends transactions through a pipeline, and collects the output
+func run(pipeline *Pipeline, transactions ...budget.Transaction) []budget.Transaction {
+	ctx := context.Background()
+	in := make(chan budget.Transaction)
+	out := pipeline.Run(ctx, in)
+
+	go func() {
+		for _, transaction := range transactions {
+			in <- transaction
+		}
+		close(in)
+	}()
+
+	var result []budget.Transaction
+	for transaction := range out {
+		result = append(result, transaction)
+	}
+
+	return result
+}
+
+// Test a pipeline built from config, and its counters
+func TestFromConfig(t *testing.T) {
+	day := time.Date(2018, time.January, 2, 0, 0, 0, 0, time.Local)
+	pipeline, err := FromConfig([]Config{
+		{Stage: "normalize-payee"},
+		{Stage: "categorize", Rules: []Rule{{Pattern: "walmart", Category: "Groceries"}}},
+		{Stage: "dedupe", Workers: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := run(pipeline,
+		budget.Transaction{Date: day, Description: "  WALMART   #1234 ", DebitPennies: 1000},
+		budget.Transaction{Date: day, Description: "WALMART #1234", DebitPennies: 1000},
+		budget.Transaction{Date: day, Description: "Paycheck", CreditPennies: 100000},
+	)
+
+	t.Run("Output", func(t *testing.T) {
+		if len(result) != 2 {
+			t.Fatalf("Expected 2 transactions, got %v", result)
+		}
+		for _, transaction := range result {
+			if transaction.Description == "WALMART #1234" && transaction.Category != "Groceries" {
+				t.Errorf("Not categorized: %v", transaction)
+			}
+		}
+	})
+	t.Run("Counters", func(t *testing.T) {
+		counters := pipeline.Counters()
+		if len(counters) != 3 {
+			t.Fatalf("Expected 3 counters, got %v", counters)
+		}
+		if dedupe := counters[2]; dedupe.In != 3 || dedupe.Out != 2 || dedupe.Dropped != 1 {
+			t.Errorf("Wrong dedupe counters: %+v", dedupe)
+		}
+	})
+}
+
+// Test the filters
+func TestFilters(t *testing.T) {
+	jan := time.Date(2018, time.January, 31, 23, 0, 0, 0, time.Local)
+	feb := time.Date(2018, time.February, 1, 0, 0, 0, 0, time.Local)
+
+	t.Run("Dates", func(t *testing.T) {
+		result := run(New(DateFilter(jan, jan)), budget.Transaction{Date: jan}, budget.Transaction{Date: feb})
+		if len(result) != 1 || !result[0].Date.Equal(jan) {
+			t.Errorf("Expected only January, got %v", result)
+		}
+	})
+	t.Run("Accounts", func(t *testing.T) {
+		result := run(New(AccountFilter("Checking")), budget.Transaction{Account: "Checking"}, budget.Transaction{Account: "Savings"})
+		if len(result) != 1 || result[0].Account != "Checking" {
+			t.Errorf("Expected only Checking, got %v", result)
+		}
+	})
+	t.Run("ConfigDates", func(t *testing.T) {
+		pipeline, err := FromConfig([]Config{{Stage: "filter-dates", Start: "2018-02-01"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := run(pipeline, budget.Transaction{Date: jan}, budget.Transaction{Date: feb})
+		if len(result) != 1 || !result[0].Date.Equal(feb) {
+			t.Errorf("Expected only February, got %v", result)
+		}
+	})
+	t.Run("BadConfig", func(t *testing.T) {
+		if _, err := FromConfig([]Config{{Stage: "frobnicate"}}); err == nil {
+			t.Fail()
+		}
+		if _, err := FromConfig([]Config{{Stage: "filter-dates", End: "someday"}}); err == nil {
+			t.Error("Expected an error for a bad date")
+		}
+	})
+}
+
+// Every sink should get every transaction
+func TestTee(t *testing.T) {
+	ctx := context.Background()
+	in := make(chan budget.Transaction)
+	outs := Tee(ctx, in, 2)
+
+	go func() {
+		in <- budget.Transaction{Index: 1}
+		in <- budget.Transaction{Index: 2}
+		close(in)
+	}()
+
+	counts := make(chan int)
+	for _, out := range outs {
+		go func(out <-chan budget.Transaction) {
+			n := 0
+			for range out {
+				n++
+			}
+			counts <- n
+		}(out)
+	}
+
+	if a, b := <-counts, <-counts; a != 2 || b != 2 {
+		t.Errorf("Expected 2 and 2, got %d and %d", a, b)
+	}
+}
+
+// Identical transactions on the same day get different keys
+func TestNumberOccurrences(t *testing.T) {
+	day := time.Date(2018, time.January, 2, 0, 0, 0, 0, time.Local)
+	coffee := budget.Transaction{Date: day, Description: "Coffee", DebitPennies: 300}
+	transactions := []budget.Transaction{coffee, coffee, {Date: day, Description: "Tea", DebitPennies: 300}, coffee}
+
+	NumberOccurrences(transactions)
+	for i, want := range []int{0, 1, 0, 2} {
+		if transactions[i].Occurrence != want {
+			t.Errorf("Transaction %d: expected occurrence %d, got %d", i, want, transactions[i].Occurrence)
+		}
+	}
+	if Key(transactions[0]) == Key(transactions[1]) || Key(transactions[0]) != Key(coffee) {
+		t.Errorf("Wrong keys: %q, %q", Key(transactions[0]), Key(transactions[1]))
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pipeline
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/budney/budget/budget"
+)
+
+// DateFilter returns a stage that drops transactions dated before start
+// or after end. Both dates are inclusive, and times of day are ignored.
+func DateFilter(start time.Time, end time.Time) *Stage {
+	start = dateOf(start)
+	end = dateOf(end)
+
+	return NewStage("filter-dates", func(transaction *budget.Transaction) bool {
+		date := dateOf(transaction.Date)
+		return !date.Before(start) && !date.After(end)
+	})
+}
+
+// AccountFilter returns a stage that drops transactions from any account
+// not listed.
+func AccountFilter(accounts ...string) *Stage {
+	keep := make(map[string]bool, len(accounts))
+	for _, account := range accounts {
+		keep[account] = true
+	}
+
+	return NewStage("filter-accounts", func(transaction *budget.Transaction) bool {
+		return keep[transaction.Account]
+	})
+}
+
+// NormalizePayee returns a stage that tidies up descriptions, by
+// trimming them and collapsing runs of whitespace, and then fills in
+// each transaction's payee, location and card suffix. The untidied
+// description is kept in RawDescription, unless that's already set. The
+// rules are tried before the built-in ones; see PayeeNormalizer.
+func NormalizePayee(rules []PayeeRule) (*Stage, error) {
+	normalizer, err := NewPayeeNormalizer(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	stage := NewStage("normalize-payee", func(transaction *budget.Transaction) bool {
+		if transaction.RawDescription == "" {
+			transaction.RawDescription = transaction.Description
+		}
+		transaction.Description = strings.Join(strings.Fields(transaction.Description), " ")
+		normalizer.Normalize(transaction)
+		return true
+	})
+
+	return stage, nil
+}
+
+// A Rule assigns a category to transactions whose description matches
+// a regular expression.
+type Rule struct {
+	Pattern  string // A regular expression, matched without regard to case
+	Category string // The category to assign
+
+	pattern *regexp.Regexp
+}
+
+// A Categorizer assigns categories to transactions by the first rule
+// their descriptions match.
+type Categorizer struct {
+	rules []Rule
+}
+
+// NewCategorizer returns a categorizer that tries the rules in order.
+func NewCategorizer(rules []Rule) (*Categorizer, error) {
+	compiled := make([]Rule, len(rules))
+	for i, rule := range rules {
+		pattern, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("category rule %q: %v", rule.Pattern, err)
+		}
+
+		compiled[i] = rule
+		compiled[i].pattern = pattern
+	}
+
+	return &Categorizer{rules: compiled}, nil
+}
+
+// Categorize sets a transaction's category from the first rule its
+// description matches, and reports whether any did. A transaction that
+// matches no rule is left alone.
+func (categorizer *Categorizer) Categorize(transaction *budget.Transaction) bool {
+	for _, rule := range categorizer.rules {
+		if rule.pattern.MatchString(transaction.Description) {
+			transaction.Category = rule.Category
+			return true
+		}
+	}
+
+	return false
+}
+
+// Categorize returns a stage that assigns each transaction the category
+// of the first rule its description matches. Transactions that already
+// have a category, or match no rule, are left alone.
+func Categorize(rules []Rule) (*Stage, error) {
+	categorizer, err := NewCategorizer(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	stage := NewStage("categorize", func(transaction *budget.Transaction) bool {
+		if transaction.Category == "" {
+			categorizer.Categorize(transaction)
+		}
+		return true
+	})
+
+	return stage, nil
+}
+
+// Dedupe returns a stage that drops transactions it has already seen.
+// Two transactions are the same if they have the same account, date,
+// type, description, amounts and balance. The stage remembers every
+// transaction, so it should only be used for one run.
+func Dedupe() *Stage {
+	var lock sync.Mutex
+	seen := make(map[string]bool)
+
+	return NewStage("dedupe", func(transaction *budget.Transaction) bool {
+		key := Key(*transaction)
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+		return true
+	})
+}
+
+// Key returns a string identifying a transaction by its contents, for
+// detecting duplicates. The Index and Category aren't part of the key,
+// since they can differ between downloads of the same transaction. The
+// Occurrence is, so that identical transactions on the same day, which
+// sources without balances can't tell apart, have different keys.
+func Key(transaction budget.Transaction) string {
+	key := fmt.Sprintf("%s|%s|%s|%s|%d|%d|%d",
+		transaction.Account, transaction.Date.Format("2006-01-02"),
+		transaction.Type, transaction.Description,
+		transaction.DebitPennies, transaction.CreditPennies, transaction.BalancePennies)
+	if transaction.Occurrence > 0 {
+		key += fmt.Sprintf("|%d", transaction.Occurrence)
+	}
+
+	return key
+}
+
+// NumberOccurrences sets the Occurrence of each transaction to the number
+// of transactions before it with the same Key, so two purchases of the
+// same coffee on the same day are kept apart. It must be called on
+// everything a source gave at once, before any stage runs.
+func NumberOccurrences(transactions []budget.Transaction) {
+	seen := make(map[string]int)
+	for i := range transactions {
+		transactions[i].Occurrence = 0
+		key := Key(transactions[i])
+		transactions[i].Occurrence = seen[key]
+		seen[key]++
+	}
+}
+
+// Enrich returns a stage that runs f on every transaction, to add
+// information to it. It never drops transactions.
+func Enrich(name string, f func(*budget.Transaction)) *Stage {
+	return NewStage(name, func(transaction *budget.Transaction) bool {
+		f(transaction)
+		return true
+	})
+}
+
+// dateOf returns the date of t, with the time of day zeroed out.
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
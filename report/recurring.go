@@ -0,0 +1,389 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/budney/budget/budget"
+)
+
+// A Period is how often a recurring payment is made.
+type Period struct {
+	Name                string // Like "monthly"
+	Years, Months, Days int    // The time between payments
+	Slack               int    // How many days early or late a payment may be
+}
+
+// Periods lists the periods that recurring payments are detected with,
+// shortest first.
+var Periods = []Period{
+	{Name: "weekly", Days: 7, Slack: 2},
+	{Name: "monthly", Months: 1, Slack: 4},
+	{Name: "annual", Years: 1, Slack: 10},
+}
+
+// after returns the date one period after t.
+func (period Period) after(t time.Time) time.Time {
+	return t.AddDate(period.Years, period.Months, period.Days)
+}
+
+// fits reports whether next is one period after last, give or take the
+// period's slack.
+func (period Period) fits(last time.Time, next time.Time) bool {
+	expected := period.after(last)
+	early := expected.AddDate(0, 0, -period.Slack)
+	late := expected.AddDate(0, 0, period.Slack)
+
+	return !next.Before(early) && !next.After(late)
+}
+
+// A PriceChange is a change in the amount of a recurring payment, which
+// then stayed at the new amount.
+type PriceChange struct {
+	Date time.Time // The first payment at the new amount
+	From int64     // The old amount, in pennies
+	To   int64     // The new amount, in pennies
+}
+
+// A Subscription is a payment made to the same payee, from the same
+// account, at a regular period.
+type Subscription struct {
+	Payee    string
+	Account  string
+	Period   Period
+	Count    int                  // How many payments were made
+	First    time.Time            // The date of the first payment
+	Last     time.Time            // The date of the latest payment
+	Next     time.Time            // When the next payment is expected
+	Average  int64                // The average payment, in pennies
+	Amount   int64                // The latest payment, in pennies
+	Changes  []PriceChange        // Changes in the amount, in date order
+	Stopped  bool                 // Whether the next payment is overdue
+	Rose     bool                 // Whether the latest change in the amount was a rise
+	Payments []budget.Transaction // The payments, in date order
+}
+
+// Status sums up a subscription as "active", "rose" or "stopped".
+func (subscription Subscription) Status() string {
+	switch {
+	case subscription.Stopped:
+		return "stopped"
+	case subscription.Rose:
+		return "rose"
+	}
+
+	return "active"
+}
+
+// Subscriptions lists the recurring payments found in a history of
+// transactions.
+type Subscriptions struct {
+	AsOf          time.Time // The day the report was made for
+	Subscriptions []Subscription
+}
+
+// DefaultTolerance is how much a recurring payment may change from one
+// time to the next, as a fraction of the earlier amount.
+const DefaultTolerance = 0.25
+
+// NewSubscriptions finds the recurring payments in the transactions. A
+// payee is the transaction's Payee, or else its description; payments
+// to the same payee from the same account are recurring if there's a
+// run of at least three of them (or two, for annual payments), each one
+// period after the last, give or take the period's slack, and each
+// within tolerance of the amount before it. Other payments to the payee,
+// like one-off purchases, are ignored. If a subscription was paused and
+// resumed, the longest run is reported, or the latest if two are as
+// long.
+//
+// A subscription has stopped if, as of asOf, its next payment is later
+// than the period's slack allows. Only debits are considered.
+func NewSubscriptions(transactions []budget.Transaction, asOf time.Time, tolerance float64) *Subscriptions {
+	subscriptions := &Subscriptions{AsOf: dateOf(asOf)}
+
+	type key struct{ account, payee string }
+	groups := make(map[key][]budget.Transaction)
+	for _, transaction := range transactions {
+		if transaction.DebitPennies <= 0 || transaction.CreditPennies != 0 {
+			continue
+		}
+		k := key{transaction.Account, payeeOf(transaction)}
+		groups[k] = append(groups[k], transaction)
+	}
+
+	for _, payments := range groups {
+		sort.SliceStable(payments, func(i, j int) bool { return payments[i].Date.Before(payments[j].Date) })
+		if subscription, ok := detect(payments, subscriptions.AsOf, tolerance); ok {
+			subscriptions.Subscriptions = append(subscriptions.Subscriptions, subscription)
+		}
+	}
+
+	sort.Slice(subscriptions.Subscriptions, func(i, j int) bool {
+		a, b := subscriptions.Subscriptions[i], subscriptions.Subscriptions[j]
+		if a.Payee != b.Payee {
+			return a.Payee < b.Payee
+		}
+		return a.Account < b.Account
+	})
+
+	return subscriptions
+}
+
+// detect decides whether payments to one payee, in date order, recur,
+// and if so describes them.
+func detect(payments []budget.Transaction, asOf time.Time, tolerance float64) (Subscription, bool) {
+	var subscription Subscription
+
+	found := false
+	for _, period := range Periods {
+		run := longestRun(payments, period, tolerance)
+		if len(run) >= 3 || (len(run) == 2 && period.Years > 0) {
+			subscription.Period = period
+			payments = run
+			found = true
+			break
+		}
+	}
+	if !found {
+		return subscription, false
+	}
+
+	subscription.Payments = payments
+	last := payments[len(payments)-1]
+	subscription.Payee = payeeOf(last)
+	subscription.Account = last.Account
+	subscription.Count = len(payments)
+	subscription.First = dateOf(payments[0].Date)
+	subscription.Last = dateOf(last.Date)
+	subscription.Next = subscription.Period.after(subscription.Last)
+	subscription.Amount = last.DebitPennies
+	subscription.Stopped = asOf.After(subscription.Next.AddDate(0, 0, subscription.Period.Slack))
+
+	var total int64
+	for i, payment := range payments {
+		total += payment.DebitPennies
+		if i == 0 || payment.DebitPennies == payments[i-1].DebitPennies {
+			continue
+		}
+
+		// A change counts if the next payment, or the one before
+		// for the latest, shows the amount is settled rather than
+		// just varying
+		settled := i+1 < len(payments) && payments[i+1].DebitPennies == payment.DebitPennies
+		if i+1 == len(payments) {
+			settled = i < 2 || payments[i-2].DebitPennies == payments[i-1].DebitPennies
+		}
+		if settled {
+			subscription.Changes = append(subscription.Changes, PriceChange{
+				Date: dateOf(payment.Date),
+				From: payments[i-1].DebitPennies,
+				To:   payment.DebitPennies,
+			})
+		}
+	}
+	subscription.Average = total / int64(len(payments))
+
+	if n := len(subscription.Changes); n > 0 {
+		change := subscription.Changes[n-1]
+		subscription.Rose = change.To > change.From
+	}
+
+	return subscription, true
+}
+
+// longestRun returns the longest run of payments, in date order, each
+// one period after the one before it and within tolerance of its
+// amount. Payments that don't fit are skipped over. If two runs are as
+// long, the later one is returned.
+func longestRun(payments []budget.Transaction, period Period, tolerance float64) []budget.Transaction {
+	if len(payments) == 0 {
+		return nil
+	}
+
+	// length[i] is the length of the longest run ending with payment i,
+	// and previous[i] the payment before it in that run, or -1
+	length := make([]int, len(payments))
+	previous := make([]int, len(payments))
+	best := 0
+	for i := range payments {
+		length[i], previous[i] = 1, -1
+		for j := 0; j < i; j++ {
+			if length[j]+1 > length[i] &&
+				period.fits(dateOf(payments[j].Date), dateOf(payments[i].Date)) &&
+				withinTolerance(payments[j].DebitPennies, payments[i].DebitPennies, tolerance) {
+				length[i], previous[i] = length[j]+1, j
+			}
+		}
+		if length[i] >= length[best] {
+			best = i
+		}
+	}
+
+	run := make([]budget.Transaction, length[best])
+	for i, k := best, len(run)-1; i >= 0; i, k = previous[i], k-1 {
+		run[k] = payments[i]
+	}
+
+	return run
+}
+
+// withinTolerance reports whether next differs from last by no more than
+// the tolerance, as a fraction of last.
+func withinTolerance(last int64, next int64, tolerance float64) bool {
+	difference := next - last
+	if difference < 0 {
+		difference = -difference
+	}
+
+	return float64(difference) <= tolerance*float64(last)
+}
+
+// payeeOf returns the name transactions are grouped by: the payee if
+// it's known, and otherwise the description, with whitespace tidied.
+func payeeOf(transaction budget.Transaction) string {
+	payee := transaction.Payee
+	if payee == "" {
+		payee = transaction.Description
+	}
+
+	return strings.Join(strings.Fields(payee), " ")
+}
+
+// Write writes the report in the named format, which is one of Formats.
+func (subscriptions *Subscriptions) Write(w io.Writer, format string) error {
+	switch format {
+	case "table":
+		return subscriptions.writeTable(w)
+	case "csv":
+		return subscriptions.writeCSV(w)
+	case "json":
+		return subscriptions.writeJSON(w)
+	}
+
+	return fmt.Errorf("unknown format %q; use one of %v", format, Formats)
+}
+
+// subscriptionsHeader names the columns of the table and CSV.
+var subscriptionsHeader = []string{"Payee", "Account", "Period", "Count", "Average", "Amount", "Last", "Next", "Status", "Change"}
+
+// rows returns a row of formatted fields per subscription.
+func (subscriptions *Subscriptions) rows() [][]string {
+	var rows [][]string
+
+	for _, s := range subscriptions.Subscriptions {
+		change := ""
+		if n := len(s.Changes); n > 0 {
+			c := s.Changes[n-1]
+			change = fmt.Sprintf("%s -> %s on %s", dollars(c.From), dollars(c.To), c.Date.Format("2006-01-02"))
+		}
+
+		rows = append(rows, []string{
+			s.Payee,
+			s.Account,
+			s.Period.Name,
+			fmt.Sprint(s.Count),
+			dollars(s.Average),
+			dollars(s.Amount),
+			s.Last.Format("2006-01-02"),
+			s.Next.Format("2006-01-02"),
+			s.Status(),
+			change,
+		})
+	}
+
+	return rows
+}
+
+// writeTable writes a table with a row per subscription.
+func (subscriptions *Subscriptions) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(subscriptionsHeader, "\t")))
+	for _, row := range subscriptions.rows() {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// writeCSV writes the same rows as writeTable, as CSV.
+func (subscriptions *Subscriptions) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(subscriptionsHeader); err != nil {
+		return err
+	}
+	for _, row := range subscriptions.rows() {
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// jsonPriceChange is how a PriceChange is written as JSON.
+type jsonPriceChange struct {
+	Date string  `json:"date"`
+	From float64 `json:"from"`
+	To   float64 `json:"to"`
+}
+
+// jsonSubscription is how a Subscription is written as JSON. Amounts
+// are in dollars.
+type jsonSubscription struct {
+	Payee   string            `json:"payee"`
+	Account string            `json:"account"`
+	Period  string            `json:"period"`
+	Count   int               `json:"count"`
+	First   string            `json:"first"`
+	Last    string            `json:"last"`
+	Next    string            `json:"next"`
+	Average float64           `json:"average"`
+	Amount  float64           `json:"amount"`
+	Status  string            `json:"status"`
+	Changes []jsonPriceChange `json:"changes"`
+}
+
+// writeJSON writes the report as a JSON object.
+func (subscriptions *Subscriptions) writeJSON(w io.Writer) error {
+	out := struct {
+		AsOf          string             `json:"asOf"`
+		Subscriptions []jsonSubscription `json:"subscriptions"`
+	}{AsOf: subscriptions.AsOf.Format("2006-01-02"), Subscriptions: []jsonSubscription{}}
+
+	for _, s := range subscriptions.Subscriptions {
+		j := jsonSubscription{
+			Payee:   s.Payee,
+			Account: s.Account,
+			Period:  s.Period.Name,
+			Count:   s.Count,
+			First:   s.First.Format("2006-01-02"),
+			Last:    s.Last.Format("2006-01-02"),
+			Next:    s.Next.Format("2006-01-02"),
+			Average: toDollars(s.Average),
+			Amount:  toDollars(s.Amount),
+			Status:  s.Status(),
+			Changes: []jsonPriceChange{},
+		}
+		for _, c := range s.Changes {
+			j.Changes = append(j.Changes, jsonPriceChange{Date: c.Date.Format("2006-01-02"), From: toDollars(c.From), To: toDollars(c.To)})
+		}
+		out.Subscriptions = append(out.Subscriptions, j)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/budney/budget/budget"
+)
+
+// Test finding recurring payments
+func TestNewSubscriptions(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.Local)
+	}
+	payment := func(date time.Time, payee string, pennies int64) budget.Transaction {
+		return budget.Transaction{Date: date, Account: "Checking", Description: "DEBIT " + payee, Payee: payee, DebitPennies: pennies}
+	}
+
+	transactions := []budget.Transaction{
+		// Monthly, with a price rise that stuck
+		payment(day(2018, time.January, 15), "NETFLIX", 999),
+		payment(day(2018, time.February, 14), "NETFLIX", 999),
+		payment(day(2018, time.March, 15), "NETFLIX", 1199),
+		payment(day(2018, time.April, 16), "NETFLIX", 1199),
+
+		// Weekly, but it stopped
+		payment(day(2018, time.January, 1), "GYM", 500),
+		payment(day(2018, time.January, 8), "GYM", 500),
+		payment(day(2018, time.January, 15), "GYM", 500),
+
+		// Annual
+		payment(day(2017, time.March, 1), "DOMAIN", 1500),
+		payment(day(2018, time.March, 3), "DOMAIN", 1500),
+
+		// Not regular
+		payment(day(2018, time.January, 3), "GROCER", 5000),
+		payment(day(2018, time.January, 5), "GROCER", 7000),
+		payment(day(2018, time.February, 20), "GROCER", 6000),
+
+		// Refunds aren't payments
+		{Date: day(2018, time.January, 20), Account: "Checking", Payee: "NETFLIX", CreditPennies: 999},
+	}
+
+	subscriptions := NewSubscriptions(transactions, day(2018, time.April, 20), DefaultTolerance)
+	if len(subscriptions.Subscriptions) != 3 {
+		t.Fatalf("Expected 3 subscriptions, got %+v", subscriptions.Subscriptions)
+	}
+	domain, gym, netflix := subscriptions.Subscriptions[0], subscriptions.Subscriptions[1], subscriptions.Subscriptions[2]
+
+	t.Run("Monthly", func(t *testing.T) {
+		if netflix.Period.Name != "monthly" || netflix.Count != 4 || netflix.Average != 1099 || netflix.Amount != 1199 {
+			t.Errorf("Wrong subscription: %+v", netflix)
+		}
+		if !netflix.Next.Equal(day(2018, time.May, 16)) || netflix.Status() != "rose" {
+			t.Errorf("Wrong next date or status: %v, %s", netflix.Next, netflix.Status())
+		}
+		if len(netflix.Changes) != 1 || netflix.Changes[0].From != 999 || netflix.Changes[0].To != 1199 {
+			t.Errorf("Wrong price changes: %+v", netflix.Changes)
+		}
+	})
+	t.Run("Weekly", func(t *testing.T) {
+		if gym.Period.Name != "weekly" || gym.Status() != "stopped" {
+			t.Errorf("Wrong subscription: %+v", gym)
+		}
+	})
+	t.Run("Annual", func(t *testing.T) {
+		if domain.Period.Name != "annual" || domain.Status() != "active" || !domain.Next.Equal(day(2019, time.March, 3)) {
+			t.Errorf("Wrong subscription: %+v", domain)
+		}
+	})
+	t.Run("Tolerance", func(t *testing.T) {
+		if len(NewSubscriptions(transactions, day(2018, time.April, 20), 0.1).Subscriptions) != 2 {
+			t.Error("Expected the price rise to be beyond a 10% tolerance")
+		}
+	})
+	t.Run("StrayPayment", func(t *testing.T) {
+		payments := append(transactions[:4:4],
+			payment(day(2018, time.February, 27), "NETFLIX", 2999),
+			payment(day(2018, time.March, 2), "NETFLIX", 499),
+		)
+		subscriptions := NewSubscriptions(payments, day(2018, time.April, 20), DefaultTolerance)
+		if len(subscriptions.Subscriptions) != 1 || subscriptions.Subscriptions[0].Count != 4 {
+			t.Errorf("Expected the stray payments to be ignored, got %+v", subscriptions.Subscriptions)
+		}
+	})
+	t.Run("Gap", func(t *testing.T) {
+		var payments []budget.Transaction
+		for _, month := range []time.Month{time.January, time.February, time.March, time.July, time.August, time.September} {
+			payments = append(payments, payment(day(2018, month, 10), "SPOTIFY", 999))
+		}
+		subscriptions := NewSubscriptions(payments, day(2018, time.September, 20), DefaultTolerance)
+		if len(subscriptions.Subscriptions) != 1 {
+			t.Fatalf("Expected 1 subscription, got %+v", subscriptions.Subscriptions)
+		}
+		if spotify := subscriptions.Subscriptions[0]; spotify.Count != 3 || !spotify.First.Equal(day(2018, time.July, 10)) || spotify.Status() != "active" {
+			t.Errorf("Expected the run since the gap, got %+v", spotify)
+		}
+	})
+	t.Run("Write", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := subscriptions.Write(&b, "csv"); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		if len(lines) != 4 || lines[3] != "NETFLIX,Checking,monthly,4,10.99,11.99,2018-04-16,2018-05-16,rose,9.99 -> 11.99 on 2018-03-15" {
+			t.Errorf("Wrong CSV: %q", lines)
+		}
+	})
+}
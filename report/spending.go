@@ -0,0 +1,244 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package report summarizes transactions read back out of the budget,
+// and writes the summaries as a table for people, or as CSV or JSON for
+// other programs.
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/budney/budget/budget"
+)
+
+// Formats lists the output formats every report can be written in.
+var Formats = []string{"table", "csv", "json"}
+
+// Uncategorized is the category of transactions that don't have one.
+const Uncategorized = "Uncategorized"
+
+// Spending totals transactions by category and month. Spending is debits
+// minus credits, so refunds reduce it, and income is negative.
+type Spending struct {
+	Months     []string                    // Every month in the range, like "2018-01", in order
+	Categories []string                    // Every category with transactions, sorted
+	Totals     map[string]map[string]int64 // Pennies spent, by category and then month
+}
+
+// NewSpending totals the transactions dated between start and end,
+// inclusive. The months of the range are all listed, even if nothing was
+// spent in them.
+func NewSpending(transactions []budget.Transaction, start time.Time, end time.Time) *Spending {
+	spending := &Spending{Months: monthsBetween(start, end), Totals: make(map[string]map[string]int64)}
+
+	start = dateOf(start)
+	end = dateOf(end)
+	for _, transaction := range transactions {
+		date := dateOf(transaction.Date)
+		if date.Before(start) || date.After(end) {
+			continue
+		}
+
+		category := transaction.Category
+		if category == "" {
+			category = Uncategorized
+		}
+		if spending.Totals[category] == nil {
+			spending.Totals[category] = make(map[string]int64)
+			spending.Categories = append(spending.Categories, category)
+		}
+		spending.Totals[category][date.Format("2006-01")] += transaction.DebitPennies - transaction.CreditPennies
+	}
+	sort.Strings(spending.Categories)
+
+	return spending
+}
+
+// CategoryTotal returns the pennies spent in a category over every month.
+func (spending *Spending) CategoryTotal(category string) int64 {
+	var total int64
+	for _, pennies := range spending.Totals[category] {
+		total += pennies
+	}
+
+	return total
+}
+
+// MonthTotal returns the pennies spent in a month over every category.
+func (spending *Spending) MonthTotal(month string) int64 {
+	var total int64
+	for _, months := range spending.Totals {
+		total += months[month]
+	}
+
+	return total
+}
+
+// Total returns the pennies spent over every category and month.
+func (spending *Spending) Total() int64 {
+	var total int64
+	for _, month := range spending.Months {
+		total += spending.MonthTotal(month)
+	}
+
+	return total
+}
+
+// Write writes the report in the named format, which is one of Formats.
+func (spending *Spending) Write(w io.Writer, format string) error {
+	switch format {
+	case "table":
+		return spending.writeTable(w)
+	case "csv":
+		return spending.writeCSV(w)
+	case "json":
+		return spending.writeJSON(w)
+	}
+
+	return fmt.Errorf("unknown format %q; use one of %v", format, Formats)
+}
+
+// writeTable writes a table with a row per category, a column per
+// month, and totals.
+func (spending *Spending) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+
+	fmt.Fprint(tw, "CATEGORY\t")
+	for _, month := range spending.Months {
+		fmt.Fprintf(tw, "%s\t", month)
+	}
+	fmt.Fprintln(tw, "TOTAL\t")
+
+	for _, row := range spending.rows() {
+		fmt.Fprintf(tw, "%s\t", row[0])
+		for _, cell := range row[1:] {
+			fmt.Fprintf(tw, "%s\t", cell)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	return tw.Flush()
+}
+
+// writeCSV writes the same rows as writeTable, as CSV.
+func (spending *Spending) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	header := append([]string{"Category"}, spending.Months...)
+	if err := cw.Write(append(header, "Total")); err != nil {
+		return err
+	}
+	for _, row := range spending.rows() {
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// rows returns a row of formatted amounts per category, and a row of
+// totals.
+func (spending *Spending) rows() [][]string {
+	var rows [][]string
+
+	for _, category := range spending.Categories {
+		row := []string{category}
+		for _, month := range spending.Months {
+			row = append(row, dollars(spending.Totals[category][month]))
+		}
+		rows = append(rows, append(row, dollars(spending.CategoryTotal(category))))
+	}
+
+	totals := []string{"Total"}
+	for _, month := range spending.Months {
+		totals = append(totals, dollars(spending.MonthTotal(month)))
+	}
+	rows = append(rows, append(totals, dollars(spending.Total())))
+
+	return rows
+}
+
+// jsonSpending is how a Spending report is written as JSON. Amounts are
+// in dollars.
+type jsonSpending struct {
+	Months     []string           `json:"months"`
+	Categories []jsonCategory     `json:"categories"`
+	Totals     map[string]float64 `json:"totals"`
+	Total      float64            `json:"total"`
+}
+
+// jsonCategory is one category's spending, by month.
+type jsonCategory struct {
+	Category string             `json:"category"`
+	Months   map[string]float64 `json:"months"`
+	Total    float64            `json:"total"`
+}
+
+// writeJSON writes the report as a JSON object.
+func (spending *Spending) writeJSON(w io.Writer) error {
+	out := jsonSpending{
+		Months:     spending.Months,
+		Categories: []jsonCategory{},
+		Totals:     make(map[string]float64),
+		Total:      toDollars(spending.Total()),
+	}
+
+	for _, category := range spending.Categories {
+		c := jsonCategory{Category: category, Months: make(map[string]float64), Total: toDollars(spending.CategoryTotal(category))}
+		for _, month := range spending.Months {
+			c.Months[month] = toDollars(spending.Totals[category][month])
+		}
+		out.Categories = append(out.Categories, c)
+	}
+	for _, month := range spending.Months {
+		out.Totals[month] = toDollars(spending.MonthTotal(month))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// dollars formats pennies as dollars and cents, without a currency sign.
+func dollars(pennies int64) string {
+	sign := ""
+	if pennies < 0 {
+		sign = "-"
+		pennies = -pennies
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, pennies/100, pennies%100)
+}
+
+// toDollars converts pennies to dollars.
+func toDollars(pennies int64) float64 {
+	return float64(pennies) / 100
+}
+
+// monthsBetween lists the months from start to end, inclusive, like
+// "2018-01".
+func monthsBetween(start time.Time, end time.Time) []string {
+	var months []string
+
+	first := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.Local)
+	for month := first; !month.After(end); month = month.AddDate(0, 1, 0) {
+		months = append(months, month.Format("2006-01"))
+	}
+
+	return months
+}
+
+// dateOf returns the date of t, with the time of day zeroed out.
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
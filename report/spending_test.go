@@ -0,0 +1,96 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/budney/budget/budget"
+)
+
+// spendingFixture returns a report on a few transactions in January and
+// March 2018
+func spendingFixture() *Spending {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2018, month, d, 12, 0, 0, 0, time.Local)
+	}
+	transactions := []budget.Transaction{
+		{Date: day(time.January, 2), Category: "Food", DebitPennies: 500},
+		{Date: day(time.January, 9), Category: "Food", DebitPennies: 1250},
+		{Date: day(time.January, 10), Category: "Food", CreditPennies: 250},
+		{Date: day(time.March, 1), DebitPennies: 100},
+		{Date: day(time.April, 1), Category: "Food", DebitPennies: 9999},
+	}
+
+	return NewSpending(transactions, day(time.January, 1), day(time.March, 31))
+}
+
+// Test totaling by category and month
+func TestNewSpending(t *testing.T) {
+	spending := spendingFixture()
+
+	t.Run("Months", func(t *testing.T) {
+		if strings.Join(spending.Months, ",") != "2018-01,2018-02,2018-03" {
+			t.Errorf("Wrong months: %v", spending.Months)
+		}
+	})
+	t.Run("Categories", func(t *testing.T) {
+		if strings.Join(spending.Categories, ",") != "Food,Uncategorized" {
+			t.Errorf("Wrong categories: %v", spending.Categories)
+		}
+	})
+	t.Run("Totals", func(t *testing.T) {
+		if got := spending.Totals["Food"]["2018-01"]; got != 1500 {
+			t.Errorf("Expected 1500 for food in January, got %d", got)
+		}
+		if got := spending.MonthTotal("2018-03"); got != 100 {
+			t.Errorf("Expected 100 in March, got %d", got)
+		}
+		if got := spending.Total(); got != 1600 {
+			t.Errorf("Expected 1600 in all, got %d", got)
+		}
+	})
+}
+
+// Test each output format
+func TestSpendingWrite(t *testing.T) {
+	spending := spendingFixture()
+
+	t.Run("CSV", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := spending.Write(&b, "csv"); err != nil {
+			t.Fatal(err)
+		}
+		want := "Category,2018-01,2018-02,2018-03,Total\n" +
+			"Food,15.00,0.00,0.00,15.00\n" +
+			"Uncategorized,0.00,0.00,1.00,1.00\n" +
+			"Total,15.00,0.00,1.00,16.00\n"
+		if b.String() != want {
+			t.Errorf("Expected:\n%s\nGot:\n%s", want, b.String())
+		}
+	})
+	t.Run("JSON", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := spending.Write(&b, "json"); err != nil {
+			t.Fatal(err)
+		}
+		var out jsonSpending
+		if err := json.Unmarshal(b.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Total != 16 || len(out.Categories) != 2 || out.Categories[0].Months["2018-01"] != 15 {
+			t.Errorf("Wrong JSON: %s", b.String())
+		}
+	})
+	t.Run("Unknown", func(t *testing.T) {
+		if err := spending.Write(&bytes.Buffer{}, "xml"); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
@@ -0,0 +1,212 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/budney/budget/budget"
+)
+
+// A Line compares what was budgeted for one category with what was
+// actually spent.
+type Line struct {
+	Category string
+	Budget   int64 // Pennies budgeted for the period
+	Actual   int64 // Pennies spent in the period: debits minus credits
+}
+
+// Remaining returns the pennies left to spend. It's negative if the
+// category is over budget.
+func (line Line) Remaining() int64 {
+	return line.Budget - line.Actual
+}
+
+// PercentUsed returns the share of the budget spent, as a percentage. A
+// category with nothing budgeted has used 0%, however much was spent.
+func (line Line) PercentUsed() float64 {
+	if line.Budget == 0 {
+		return 0
+	}
+
+	return 100 * float64(line.Actual) / float64(line.Budget)
+}
+
+// Variance compares budgeted and actual spending over a period, usually
+// the period of one budget spreadsheet.
+type Variance struct {
+	Start time.Time // The first day of the period
+	End   time.Time // The last day of the period
+	Lines []Line    // A line per category, sorted by category
+	Total Line      // The sum of the lines
+}
+
+// NewVariance compares the monthly targets, in pennies, with the
+// transactions dated between start and end, inclusive. Each target is
+// multiplied by the number of months the period touches. Categories with
+// spending but no target, and targets with no spending, get lines too.
+func NewVariance(transactions []budget.Transaction, targets map[string]int64, start time.Time, end time.Time) *Variance {
+	variance := &Variance{Start: dateOf(start), End: dateOf(end), Total: Line{Category: "Total"}}
+	months := int64(len(monthsBetween(start, end)))
+	spending := NewSpending(transactions, start, end)
+
+	lines := make(map[string]*Line)
+	line := func(category string) *Line {
+		if lines[category] == nil {
+			lines[category] = &Line{Category: category}
+		}
+		return lines[category]
+	}
+
+	for category, monthly := range targets {
+		line(category).Budget = monthly * months
+	}
+	for _, category := range spending.Categories {
+		line(category).Actual = spending.CategoryTotal(category)
+	}
+
+	for _, l := range lines {
+		variance.Lines = append(variance.Lines, *l)
+		variance.Total.Budget += l.Budget
+		variance.Total.Actual += l.Actual
+	}
+	sort.Slice(variance.Lines, func(i, j int) bool { return variance.Lines[i].Category < variance.Lines[j].Category })
+
+	return variance
+}
+
+// Write writes the report in the named format, which is one of Formats.
+func (variance *Variance) Write(w io.Writer, format string) error {
+	switch format {
+	case "table":
+		return variance.writeTable(w)
+	case "csv":
+		return variance.writeCSV(w)
+	case "json":
+		return variance.writeJSON(w)
+	}
+
+	return fmt.Errorf("unknown format %q; use one of %v", format, Formats)
+}
+
+// varianceHeader names the columns of the table, CSV and worksheet.
+var varianceHeader = []string{"Category", "Budget", "Actual", "Remaining", "% Used"}
+
+// allLines returns the lines followed by the total.
+func (variance *Variance) allLines() []Line {
+	return append(append([]Line(nil), variance.Lines...), variance.Total)
+}
+
+// writeTable writes a table with a row per category, and totals.
+func (variance *Variance) writeTable(w io.Writer) error {
+	fmt.Fprintf(w, "%s - %s\n", variance.Start.Format("2006-01-02"), variance.End.Format("2006-01-02"))
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "CATEGORY\tBUDGET\tACTUAL\tREMAINING\t% USED\t")
+	for _, line := range variance.allLines() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.1f\t\n", line.Category,
+			dollars(line.Budget), dollars(line.Actual), dollars(line.Remaining()), line.PercentUsed())
+	}
+
+	return tw.Flush()
+}
+
+// writeCSV writes the same rows as writeTable, as CSV.
+func (variance *Variance) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(varianceHeader); err != nil {
+		return err
+	}
+	for _, line := range variance.allLines() {
+		row := []string{line.Category, dollars(line.Budget), dollars(line.Actual), dollars(line.Remaining()), fmt.Sprintf("%.1f", line.PercentUsed())}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// jsonLine is how a Line is written as JSON. Amounts are in dollars.
+type jsonLine struct {
+	Category    string  `json:"category"`
+	Budget      float64 `json:"budget"`
+	Actual      float64 `json:"actual"`
+	Remaining   float64 `json:"remaining"`
+	PercentUsed float64 `json:"percentUsed"`
+}
+
+// jsonVariance is how a Variance report is written as JSON.
+type jsonVariance struct {
+	Start      string     `json:"start"`
+	End        string     `json:"end"`
+	Categories []jsonLine `json:"categories"`
+	Total      jsonLine   `json:"total"`
+}
+
+// toJSON converts a line for writing as JSON.
+func (line Line) toJSON() jsonLine {
+	return jsonLine{
+		Category:    line.Category,
+		Budget:      toDollars(line.Budget),
+		Actual:      toDollars(line.Actual),
+		Remaining:   toDollars(line.Remaining()),
+		PercentUsed: line.PercentUsed(),
+	}
+}
+
+// writeJSON writes the report as a JSON object.
+func (variance *Variance) writeJSON(w io.Writer) error {
+	out := jsonVariance{
+		Start:      variance.Start.Format("2006-01-02"),
+		End:        variance.End.Format("2006-01-02"),
+		Categories: []jsonLine{},
+		Total:      variance.Total.toJSON(),
+	}
+	for _, line := range variance.Lines {
+		out.Categories = append(out.Categories, line.toJSON())
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// Rows returns the report as spreadsheet rows, for writing to the Summary
+// worksheet: the period, a header, a row per category, and the total.
+// Amounts are in dollars, and percentages are fractions, so the sheet can
+// format them.
+func (variance *Variance) Rows() [][]interface{} {
+	rows := [][]interface{}{
+		{"Period", variance.Start.Format("1/2/2006"), variance.End.Format("1/2/2006")},
+		{},
+	}
+
+	header := make([]interface{}, len(varianceHeader))
+	for i, name := range varianceHeader {
+		header[i] = name
+	}
+	rows = append(rows, header)
+
+	for _, line := range variance.allLines() {
+		rows = append(rows, []interface{}{
+			line.Category,
+			toDollars(line.Budget),
+			toDollars(line.Actual),
+			toDollars(line.Remaining()),
+			line.PercentUsed() / 100,
+		})
+	}
+
+	return rows
+}
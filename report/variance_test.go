@@ -0,0 +1,43 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package report
+
+import (
+	"testing"
+	"time"
+
+	"github.com/budney/budget/budget"
+)
+
+// Test comparing targets with spending
+func TestNewVariance(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2018, month, d, 0, 0, 0, 0, time.Local)
+	}
+	transactions := []budget.Transaction{
+		{Date: day(time.January, 2), Category: "Food", DebitPennies: 15000},
+		{Date: day(time.February, 2), Category: "Fun", DebitPennies: 2000},
+	}
+	targets := map[string]int64{"Food": 10000, "Rent": 50000}
+
+	variance := NewVariance(transactions, targets, day(time.January, 1), day(time.February, 28))
+	if len(variance.Lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %+v", variance.Lines)
+	}
+
+	food := variance.Lines[0]
+	if food.Category != "Food" || food.Budget != 20000 || food.Actual != 15000 || food.Remaining() != 5000 || food.PercentUsed() != 75 {
+		t.Errorf("Wrong food line: %+v", food)
+	}
+	if fun := variance.Lines[1]; fun.Budget != 0 || fun.Remaining() != -2000 || fun.PercentUsed() != 0 {
+		t.Errorf("Wrong fun line: %+v", fun)
+	}
+	if total := variance.Total; total.Budget != 120000 || total.Actual != 17000 {
+		t.Errorf("Wrong total: %+v", total)
+	}
+	if rows := variance.Rows(); len(rows) != 7 {
+		t.Errorf("Expected 7 rows, got %v", rows)
+	}
+}
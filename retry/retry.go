@@ -0,0 +1,206 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package retry provides a policy for retrying Google API calls that
+// fail for transient reasons, such as rate limiting (429) or a service
+// being briefly unavailable (503). Retries back off exponentially, with
+// jitter, and honor any Retry-After header in the response. Errors that
+// can't be fixed by retrying are returned immediately.
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Defaults for any Policy fields that are left zero
+const (
+	DefaultMaxAttempts  = 5
+	DefaultInitialDelay = time.Second
+	DefaultMaxDelay     = time.Minute
+)
+
+// sleep waits for the specified time, or until ctx is done. It's
+// replaced when testing.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Policy says how many times to try an operation, and how long to wait
+// between tries. It can be read from the config file, where the delays
+// are written as strings such as "500ms" or "2s".
+type Policy struct {
+	MaxAttempts  int      // The most times to try, including the first
+	InitialDelay Duration // The delay before the first retry
+	MaxDelay     Duration // The longest delay between retries
+}
+
+// Duration is a time.Duration that's written in config files as a
+// string, such as "1m30s", or as a number of seconds.
+type Duration time.Duration
+
+// UnmarshalJSON accepts a duration string or a number of seconds.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration %s", b)
+	}
+
+	return nil
+}
+
+// MarshalJSON writes a duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// withDefaults returns a copy of the policy, with defaults filled in.
+func (p Policy) withDefaults() Policy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultMaxAttempts
+	}
+	if p.InitialDelay <= 0 {
+		p.InitialDelay = Duration(DefaultInitialDelay)
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = Duration(DefaultMaxDelay)
+	}
+
+	return p
+}
+
+// Do calls op until it succeeds, fails with a permanent error, or has
+// been tried MaxAttempts times. It returns op's last error. The name is
+// used to log retries.
+//
+// If ctx is done, Do stops waiting to retry, and returns ctx's error.
+// It never interrupts op itself; op should use ctx if it can be stopped
+// safely.
+func (p Policy) Do(ctx context.Context, name string, op func() error) error {
+	p = p.withDefaults()
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if err = op(); err == nil {
+			return nil
+		}
+
+		retryable, retryAfter := Retryable(err)
+		if !retryable || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		delay := p.Backoff(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+
+		log.Printf("%s failed (attempt %d of %d), retrying in %s: %v", name, attempt, p.MaxAttempts, delay, err)
+		if err = sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Backoff returns how long to wait after the specified attempt. The
+// delay doubles with each attempt, up to MaxDelay, and a random delay
+// between half of that and all of it is chosen, so that clients that
+// failed together don't all retry together.
+func (p Policy) Backoff(attempt int) time.Duration {
+	p = p.withDefaults()
+
+	delay := time.Duration(p.InitialDelay)
+	for i := 1; i < attempt && delay < time.Duration(p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if delay > time.Duration(p.MaxDelay) {
+		delay = time.Duration(p.MaxDelay)
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// Retryable reports whether err is a transient error that might go
+// away if the operation is retried. If the server said how long to
+// wait with a Retry-After header, that's returned too.
+func Retryable(err error) (bool, time.Duration) {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests,
+			http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true, retryAfter(apiErr.Header)
+		}
+		return false, 0
+	}
+
+	// Connections that timed out or broke mid-request are worth another try
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true, 0
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true, 0
+	}
+
+	return false, 0
+}
+
+// retryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date. It returns 0 if there's no usable header.
+func retryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(t); delay > 0 {
+			return delay
+		}
+	}
+
+	return 0
+}
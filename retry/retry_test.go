@@ -0,0 +1,119 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Test which errors are retried, and how many times
+func TestDo(t *testing.T) {
+	var delays []time.Duration
+	realSleep := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	defer func() { sleep = realSleep }()
+	ctx := context.Background()
+
+	policy := Policy{MaxAttempts: 3, InitialDelay: Duration(time.Second), MaxDelay: Duration(time.Minute)}
+
+	calls := 0
+	t.Run("Transient", func(t *testing.T) {
+		delays, calls = nil, 0
+		err := policy.Do(ctx, "test", func() error {
+			if calls++; calls < 3 {
+				return &googleapi.Error{Code: http.StatusServiceUnavailable}
+			}
+			return nil
+		})
+		if err != nil || calls != 3 || len(delays) != 2 {
+			t.Errorf("Expected success after 3 calls and 2 delays, got %v, %d, %v", err, calls, delays)
+		}
+	})
+	t.Run("GiveUp", func(t *testing.T) {
+		calls = 0
+		err := policy.Do(ctx, "test", func() error {
+			calls++
+			return &googleapi.Error{Code: http.StatusTooManyRequests}
+		})
+		if err == nil || calls != 3 {
+			t.Errorf("Expected failure after 3 calls, got %v, %d", err, calls)
+		}
+	})
+	t.Run("Permanent", func(t *testing.T) {
+		calls = 0
+		err := policy.Do(ctx, "test", func() error {
+			calls++
+			return &googleapi.Error{Code: http.StatusForbidden}
+		})
+		if err == nil || calls != 1 {
+			t.Errorf("Expected failure after 1 call, got %v, %d", err, calls)
+		}
+	})
+	t.Run("RetryAfter", func(t *testing.T) {
+		delays, calls = nil, 0
+		header := http.Header{"Retry-After": []string{"90"}}
+		policy.Do(ctx, "test", func() error {
+			if calls++; calls < 2 {
+				return &googleapi.Error{Code: http.StatusTooManyRequests, Header: header}
+			}
+			return nil
+		})
+		if len(delays) != 1 || delays[0] != 90*time.Second {
+			t.Errorf("Expected to wait 90s, got %v", delays)
+		}
+	})
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		calls = 0
+		err := policy.Do(ctx, "test", func() error {
+			calls++
+			return nil
+		})
+		if err != context.Canceled || calls != 0 {
+			t.Errorf("Expected cancellation before any calls, got %v, %d", err, calls)
+		}
+	})
+	t.Run("Other", func(t *testing.T) {
+		if retryable, _ := Retryable(errors.New("bad request")); retryable {
+			t.Fail()
+		}
+	})
+}
+
+// Backoff should grow, but stay under MaxDelay
+func TestBackoff(t *testing.T) {
+	policy := Policy{InitialDelay: Duration(time.Second), MaxDelay: Duration(10 * time.Second)}
+
+	for attempt, max := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		if attempt == 0 {
+			continue
+		}
+		if delay := policy.Backoff(attempt); delay < max/2 || delay > max {
+			t.Errorf("Attempt %d: delay %v not in [%v, %v]", attempt, delay, max/2, max)
+		}
+	}
+}
+
+// Durations can be written as strings or seconds
+func TestDuration(t *testing.T) {
+	var policy Policy
+	if err := json.Unmarshal([]byte(`{"InitialDelay": "500ms", "MaxDelay": 30}`), &policy); err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(policy.InitialDelay) != 500*time.Millisecond || time.Duration(policy.MaxDelay) != 30*time.Second {
+		t.Errorf("Got %v, %v", policy.InitialDelay, policy.MaxDelay)
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package vault provides an encrypted local store for online banking
+// credentials. Each entry is keyed by the name of an institution, and
+// holds the username, password and security questions for logging in.
+//
+// The vault is a single file. Its contents are encrypted with AES-256-GCM,
+// using a key derived from a passphrase with scrypt, so the file is
+// useless without the passphrase and can't be tampered with undetected.
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Version is the current vault file format version.
+const Version = 1
+
+// Parameters for deriving the encryption key from the passphrase. These
+// are stored in the vault file, so they can be raised in the future
+// without breaking existing vaults.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	keyLen  = 32 // AES-256
+	saltLen = 16
+)
+
+// Limits on the key derivation parameters read from a vault file, so a
+// damaged or tampered file can't make Open use gigabytes of memory or
+// run for hours. The memory scrypt needs is 128*N*r bytes.
+const (
+	maxScryptN      = 1 << 20
+	maxScryptR      = 32
+	maxScryptP      = 16
+	maxScryptMemory = 1 << 30
+)
+
+// ErrBadPassphrase is returned when a vault can't be decrypted, which
+// means that either the passphrase is wrong or the file was tampered with.
+var ErrBadPassphrase = errors.New("wrong passphrase, or vault is corrupt")
+
+// Entry holds the credentials for one institution.
+type Entry struct {
+	Username          string
+	Password          string
+	SecurityQuestions map[string]string
+}
+
+// Vault holds the decrypted credentials for every institution, along with
+// what's needed to encrypt them again when saving.
+type Vault struct {
+	Entries map[string]Entry
+
+	fileName   string
+	passphrase []byte
+}
+
+// file is the on-disk format of a vault. Byte slices are base64-encoded
+// by the JSON encoder.
+type file struct {
+	Version    int
+	N, R, P    int
+	Salt       []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// Open reads and decrypts the vault in the specified file. If the file
+// doesn't exist, it returns an empty vault which will be created the
+// first time it's saved.
+func Open(fileName string, passphrase []byte) (*Vault, error) {
+	vault := &Vault{
+		Entries:    make(map[string]Entry),
+		fileName:   fileName,
+		passphrase: passphrase,
+	}
+
+	b, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return vault, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f file
+	if err = json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("vault %s: %v", fileName, err)
+	}
+	if f.Version != Version {
+		return nil, fmt.Errorf("vault %s: unsupported version %d", fileName, f.Version)
+	}
+
+	if err = checkParameters(f.N, f.R, f.P); err != nil {
+		return nil, fmt.Errorf("vault %s: %v", fileName, err)
+	}
+
+	aead, err := newAEAD(passphrase, f.Salt, f.N, f.R, f.P)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Nonce) != aead.NonceSize() {
+		return nil, ErrBadPassphrase
+	}
+
+	plaintext, err := aead.Open(nil, f.Nonce, f.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+
+	if err = json.Unmarshal(plaintext, &vault.Entries); err != nil {
+		return nil, fmt.Errorf("vault %s: %v", fileName, err)
+	}
+
+	return vault, nil
+}
+
+// Save encrypts the vault and writes it back to its file. A fresh salt
+// and nonce are used every time. The file is replaced atomically, and is
+// readable only by its owner.
+func (vault *Vault) Save() error {
+	plaintext, err := json.Marshal(vault.Entries)
+	if err != nil {
+		return err
+	}
+
+	f := file{Version: Version, N: scryptN, R: scryptR, P: scryptP}
+	f.Salt = make([]byte, saltLen)
+	if _, err = rand.Read(f.Salt); err != nil {
+		return err
+	}
+
+	aead, err := newAEAD(vault.passphrase, f.Salt, f.N, f.R, f.P)
+	if err != nil {
+		return err
+	}
+
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(f.Nonce); err != nil {
+		return err
+	}
+	f.Ciphertext = aead.Seal(nil, f.Nonce, plaintext, nil)
+
+	b, err := json.MarshalIndent(f, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(vault.fileName, b)
+}
+
+// Lookup returns the entry for the named institution, if there is one.
+func (vault *Vault) Lookup(name string) (Entry, bool) {
+	entry, ok := vault.Entries[name]
+	return entry, ok
+}
+
+// Add adds or replaces the entry for the named institution.
+func (vault *Vault) Add(name string, entry Entry) {
+	vault.Entries[name] = entry
+}
+
+// Remove deletes the entry for the named institution. It returns an
+// error if there was no such entry.
+func (vault *Vault) Remove(name string) error {
+	if _, ok := vault.Entries[name]; !ok {
+		return fmt.Errorf("no vault entry for %q", name)
+	}
+
+	delete(vault.Entries, name)
+	return nil
+}
+
+// Names returns the names of all the institutions in the vault, sorted.
+func (vault *Vault) Names() []string {
+	names := make([]string, 0, len(vault.Entries))
+	for name := range vault.Entries {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// checkParameters returns an error if the scrypt parameters from a vault
+// file are beyond the limits, or aren't ones scrypt accepts.
+func checkParameters(n, r, p int) error {
+	switch {
+	case n <= 1 || n&(n-1) != 0 || n > maxScryptN:
+		return fmt.Errorf("scrypt N must be a power of 2 up to %d, not %d", maxScryptN, n)
+	case r < 1 || r > maxScryptR:
+		return fmt.Errorf("scrypt r must be from 1 to %d, not %d", maxScryptR, r)
+	case p < 1 || p > maxScryptP:
+		return fmt.Errorf("scrypt p must be from 1 to %d, not %d", maxScryptP, p)
+	case 128*int64(n)*int64(r) > maxScryptMemory:
+		return fmt.Errorf("scrypt N=%d and r=%d need more than %d bytes", n, r, maxScryptMemory)
+	}
+
+	return nil
+}
+
+// newAEAD derives a key from the passphrase and salt, and returns an
+// AES-GCM cipher using that key.
+func newAEAD(passphrase []byte, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, n, r, p, keyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// writeFile writes b to a temporary file next to fileName, and then
+// renames it into place, so that a crash can't leave a truncated vault.
+func writeFile(fileName string, b []byte) error {
+	dir := filepath.Dir(fileName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fileName)
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Len Budney. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vault
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Save a vault, and read it back with the right and wrong passphrases
+func TestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "vault.json")
+
+	vault, err := Open(fileName, []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("Can't open a new vault: %v", err)
+	}
+	vault.Add("td", Entry{Username: "me", Password: "hunter2", SecurityQuestions: map[string]string{"Pet?": "Rex"}})
+	vault.Add("cu", Entry{Username: "also me", Password: "swordfish"})
+	if err = vault.Save(); err != nil {
+		t.Fatalf("Can't save vault: %v", err)
+	}
+
+	t.Run("Mode", func(t *testing.T) {
+		info, err := os.Stat(fileName)
+		if err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("Vault should be mode 0600: %v %v", info.Mode(), err)
+		}
+	})
+	t.Run("RightPassphrase", func(t *testing.T) {
+		vault, err := Open(fileName, []byte("correct horse"))
+		if err != nil {
+			t.Fatalf("Can't reopen vault: %v", err)
+		}
+		entry, ok := vault.Lookup("td")
+		if !ok || entry.Password != "hunter2" || entry.SecurityQuestions["Pet?"] != "Rex" {
+			t.Errorf("Wrong entry: %v", entry)
+		}
+		if names := vault.Names(); len(names) != 2 || names[0] != "cu" {
+			t.Errorf("Wrong names: %v", names)
+		}
+	})
+	t.Run("WrongPassphrase", func(t *testing.T) {
+		if _, err := Open(fileName, []byte("battery staple")); err != ErrBadPassphrase {
+			t.Errorf("Expected ErrBadPassphrase, got %v", err)
+		}
+	})
+	t.Run("Remove", func(t *testing.T) {
+		if err := vault.Remove("cu"); err != nil {
+			t.Error(err)
+		}
+		if err := vault.Remove("cu"); err == nil {
+			t.Error("Removing a missing entry should fail")
+		}
+	})
+}
+
+// Key derivation parameters from the file must be within limits
+func TestCheckParameters(t *testing.T) {
+	if err := checkParameters(scryptN, scryptR, scryptP); err != nil {
+		t.Errorf("The defaults should be allowed: %v", err)
+	}
+	for _, nrp := range [][3]int{{0, 8, 1}, {1000, 8, 1}, {1 << 30, 8, 1}, {1 << 15, 0, 1}, {1 << 15, 8, 1 << 20}, {1 << 20, 32, 1}} {
+		if err := checkParameters(nrp[0], nrp[1], nrp[2]); err == nil {
+			t.Errorf("Expected an error for %v", nrp)
+		}
+	}
+}